@@ -1,8 +1,14 @@
 package gcache
 
 import (
+	"container/list"
 	"context"
+	"math/rand"
+	"os"
+	"os/signal"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -12,92 +18,758 @@ const (
 	DEFAULT_PERSIST_INTERVAL time.Duration = time.Minute * 2
 )
 
+// IsNeverExpire reports whether d, e.g. returned by GetTTL, is NEVER_EXPIRE,
+// which being negative must not be used as a duration.
+func IsNeverExpire(d time.Duration) bool {
+	return d == NEVER_EXPIRE
+}
+
+// Cache is a view of a cache core, whose keys are prefixed by the namespace of
+// the view, see Namespace. New returns a view without namespace.
 type Cache struct {
-	ctx           context.Context
-	cancel        context.CancelFunc
-	mtx           sync.RWMutex
-	persistItems  map[string]Item
-	volatileItems map[string]Item
-	changed       bool
-	w             watcher
-	wg            sync.WaitGroup
-	persister     Persister
+	*core
+	prefix string
+}
+
+type core struct {
+	ctx            context.Context
+	cancel         context.CancelFunc
+	mtx            sync.RWMutex
+	persistItems   map[string]Item
+	volatileItems  map[string]Item
+	changes        int                      // number of changes since the last persist
+	seq            uint64                   // bumped on every item write, see Item.version
+	emptyCh        chan struct{}            // closed once the cache gets empty, see WaitEmpty
+	timers         map[string]*time.Timer   // per-item expiry timers, nil if disabled
+	expiries       expiryHeap               // expiry index of volatileItems, see indexExpiry
+	recencyMtx     sync.Mutex               // guards recency and recencyKeys
+	recency        *list.List               // volatile keys, most recently used first, see touch
+	recencyKeys    map[string]*list.Element // elements of recency by key
+	waiters        map[string]*waiter       // see GetWait
+	subs           map[*subscriber]struct{} // see Watch
+	droppedEvents  uint64                   // atomic, see DroppedEvents
+	hits           uint64                   // atomic, see Stats
+	misses         uint64                   // atomic, see Stats
+	loaderCalls    uint64                   // atomic, see Stats
+	loaderErrors   uint64                   // atomic, see Stats
+	loaderNanos    int64                    // atomic, see Stats
+	lastPersistNs  int64                    // atomic, see LastPersistTime
+	onTick         func(stats Stats)        // see OnTick
+	pendingExpired []expiredItem            // expired items to report, see unlock
+	pendingLogErr  error                    // failed log append to report, see unlock
+	lastCompactMs  int64                    // see WithCompactInterval
+	w              watcher
+	wg             sync.WaitGroup
+	closeOnce      sync.Once
+	persister      Persister
+	oplog          LogPersister        // persister if it is a LogPersister, nil otherwise
+	partial        PartialPersister    // persister if it is a PartialPersister, nil otherwise
+	persistErr     error               // error of the last persist
+	dirty          map[string]struct{} // keys written since the last save, if partial
+	loadMtx        sync.Mutex
+	loads          map[string]*loadCall    // in-flight loads, see WithLoader
+	writeLocks     [kWriteLocks]sync.Mutex // see lockWrites
+	writesMtx      sync.Mutex
+	writes         map[string]pendingWrite // buffered writes, see WithWriteBehind
+	writesBytes    int64                   // approximate size of writes, see PendingPersistBytes
+	flushMtx       sync.Mutex              // serializes flushWrites
+	saveMtx        sync.Mutex              // serializes save
+	opts           options
+	clock          Clock
 }
 
-func New(ctx context.Context, cleanupInterval, persistInterval time.Duration, persister Persister) (*Cache, error) {
-	c := new(Cache)
+// New creates a cache which removes expired items every cleanupInterval and,
+// if persister is not nil, saves the items every persistInterval. A
+// non-positive interval disables the periodic task; the cache still persists
+// on Close and Flush.
+func New(ctx context.Context, cleanupInterval, persistInterval time.Duration, persister Persister, opts ...Option) (*Cache, error) {
+	o := options{
+		cleanupInterval: cleanupInterval,
+		persistInterval: persistInterval,
+		persister:       persister,
+		clock:           realClock{},
+		defaultTTL:      NEVER_EXPIRE,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	c := &Cache{core: new(core)}
 
+	c.opts = o
+	c.clock = o.clock
 	c.ctx, c.cancel = context.WithCancel(ctx)
-	c.persistItems = make(map[string]Item)
-	c.volatileItems = make(map[string]Item)
-	c.changed = false
-	c.w.cleanupInterval = cleanupInterval
-	c.w.persistInterval = persistInterval
-	c.persister = persister
-
-	if persister != nil {
-		if items, err := persister.Load(); err != nil {
-			return nil, err
-		} else {
-			for key, item := range items {
-				if item.neverExpire() {
-					c.persistItems[key] = item
-				} else {
-					c.volatileItems[key] = item
-				}
+	c.persistItems = make(map[string]Item, o.initialCapacity)
+	c.volatileItems = make(map[string]Item, o.initialCapacity)
+	c.changes = 0
+	c.seq = uint64(time.Now().UnixNano()) // keep versions unique across restarts
+	c.lastCompactMs = c.nowMs()
+	c.w.cleanupInterval = o.cleanupInterval
+	c.w.persistInterval = o.persistInterval
+	c.w.cleanupCh = make(chan time.Duration)
+	c.w.persistCh = make(chan time.Duration)
+	c.w.jitter = o.jitter
+	c.w.rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	c.persister = o.persister
+	c.oplog, _ = o.persister.(LogPersister)
+	if c.partial, _ = o.persister.(PartialPersister); c.partial != nil {
+		c.dirty = make(map[string]struct{})
+	}
+	if o.expiryTimers {
+		c.timers = make(map[string]*time.Timer)
+	}
+	if o.trackRecency {
+		c.recency = list.New()
+		c.recencyKeys = make(map[string]*list.Element)
+	}
+
+	if c.persister != nil {
+		if err := c.loadPersisted(); err != nil {
+			if !o.failOpen {
+				return nil, err
+			}
+			c.persistErr = err
+			if o.onPersistError != nil {
+				o.onPersistError(err)
 			}
 		}
+		c.evict("")
 	}
 
 	c.wg.Add(1)
-	go c.w.Run(c.ctx, &c.wg, c.persister, c.cleanup, c.persist)
+	go c.w.Run(c.ctx, &c.wg, c.persister != nil || c.opts.writeBehind, c.tick, c.persist)
 
 	return c, nil
 }
 
+// loadPersisted loads the items of the persister, streaming them if it is a
+// StreamLoader. On error, the cache is left empty.
+func (c *Cache) loadPersisted() error {
+	var err error
+	if sl, ok := c.persister.(StreamLoader); ok {
+		err = sl.LoadEach(c.sizeFor, c.insertLoaded)
+	} else {
+		var items map[string]Item
+		if items, err = c.persister.Load(); err == nil {
+			persistent := countPersistent(items)
+			c.sizeFor(persistent, len(items)-persistent)
+			for key, item := range items {
+				c.insertLoaded(key, item)
+			}
+		}
+	}
+
+	if err != nil {
+		c.persistItems = make(map[string]Item, c.opts.initialCapacity)
+		c.volatileItems = make(map[string]Item, c.opts.initialCapacity)
+		c.expiries = nil
+		c.stopAllTimers()
+	}
+	return err
+}
+
+// insertLoaded inserts a loaded item with a new version.
+func (c *Cache) insertLoaded(key string, item Item) {
+	c.seq++
+	item.version = c.seq
+	if item.neverExpire() {
+		c.persistItems[key] = item
+	} else {
+		c.volatileItems[key] = item
+		c.indexExpiry(key, item.ExpireMs)
+		c.scheduleTimer(key, item.ExpireMs)
+		c.touch(key, true)
+	}
+}
+
+// sizeFor allocates the maps big enough for the items to be loaded, if they
+// outgrow the InitialCapacity.
+func (c *Cache) sizeFor(persistent, volatile int) {
+	if persistent+volatile <= c.opts.initialCapacity {
+		return
+	}
+	c.persistItems = make(map[string]Item, persistent)
+	c.volatileItems = make(map[string]Item, volatile)
+	c.expiries = make(expiryHeap, 0, volatile)
+}
+
+// NewWithClock creates a cache like New, telling time by clock.
+func NewWithClock(ctx context.Context, cleanupInterval, persistInterval time.Duration, persister Persister, clock Clock, opts ...Option) (*Cache, error) {
+	return New(ctx, cleanupInterval, persistInterval, persister, append(opts[:len(opts):len(opts)], WithClock(clock))...)
+}
+
+// NewFromMap creates a cache with the default intervals and inserts all
+// entries of data with the given ttl. Use options to override the intervals
+// or to set a persister; entries of data win over the persisted ones. Fails
+// like SetChecked if an entry is rejected.
+func NewFromMap(ctx context.Context, data map[string]interface{}, ttl time.Duration, opts ...Option) (*Cache, error) {
+	c, err := New(ctx, DEFAULT_CLEANUP_INTERVAL, DEFAULT_PERSIST_INTERVAL, nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkTTL(ttl); err != nil {
+		c.Close()
+		return nil, err
+	}
+	for key, val := range data {
+		if err := c.validate(c.key(key), val); err != nil {
+			c.Close()
+			return nil, err
+		}
+		if c.opts.typeCheck {
+			if err := checkRegistered(val); err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+	}
+
+	c.mtx.Lock()
+	for key, val := range data {
+		c.set(c.key(key), val, ttl)
+	}
+	c.unlock()
+
+	return c, nil
+}
+
+// Close stops the background watcher and blocks until the final persist is
+// done. It is safe to call Close multiple times; every call returns the error
+// of the final persist, if any.
 func (c *Cache) Close() error {
-	c.cancel()
-	c.wg.Wait()
+	c.closeOnce.Do(func() {
+		c.cancel()
+		c.wg.Wait()
+	})
+	return c.w.err
+}
+
+// SetCleanupInterval changes the cleanup interval of a running cache, a
+// non-positive d disables the periodic cleanup.
+func (c *Cache) SetCleanupInterval(d time.Duration) {
+	c.w.setInterval(c.ctx, c.w.cleanupCh, d)
+}
+
+// SetPersistInterval changes the persist interval of a running cache, a
+// non-positive d disables the periodic persist.
+func (c *Cache) SetPersistInterval(d time.Duration) {
+	c.w.setInterval(c.ctx, c.w.persistCh, d)
+}
+
+// LastPersistError returns the error of the last persist or failed log
+// append, nil if it succeeded.
+func (c *Cache) LastPersistError() error {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	return c.persistErr
+}
+
+// LastPersistTime returns the time of the last successful save, zero if the
+// cache hasn't saved yet, e.g. to alert if persisting stalls. Persists with
+// nothing changed don't save, so they don't count. It doesn't lock the cache.
+func (c *Cache) LastPersistTime() time.Time {
+	ns := atomic.LoadInt64(&c.lastPersistNs)
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// Flush persists the cache immediately instead of waiting for the next persist
+// tick. It is a no-op if there is no persister or nothing changed.
+func (c *Cache) Flush() error {
+	return c.persist(true)
+}
+
+// Clear removes all items from the cache, or only those of the namespace.
+func (c *Cache) Clear() {
+	c.mtx.Lock()
+	if c.prefix != "" {
+		c.clearNamespace()
+		c.unlock()
+		return
+	}
+	if len(c.subs) > 0 || c.oplog != nil || c.dirty != nil || c.opts.onExpired != nil {
+		for key, item := range c.persistItems {
+			c.record(key, EventDel, item)
+		}
+		for key, item := range c.volatileItems {
+			c.record(key, EventDel, item)
+		}
+	}
+	c.persistItems = make(map[string]Item, c.opts.initialCapacity)
+	c.volatileItems = make(map[string]Item, c.opts.initialCapacity)
+	c.expiries = nil
+	c.stopAllTimers()
+	c.changes++
+	c.notifyEmpty()
+	c.unlock()
+}
+
+// CopyFrom copies the unexpired items of src, keeping their expiration and
+// overwriting existing keys, e.g. to start warm from another instance. Slice
+// and map values are deep copied, so both caches can be used meanwhile. Keys
+// are copied as stored, so a KeyTransform of c doesn't apply again. Fails like
+// SetChecked if an item is rejected by c, and then copies nothing.
+func (c *Cache) CopyFrom(src *Cache) error {
+	items := make(map[string]Item)
+	src.mtx.RLock()
+	nowMs := src.nowMs()
+	for key, item := range src.persistItems {
+		if k, own := src.ownKey(key); own {
+			item.Object = cloneObject(item.Object)
+			items[k] = item
+		}
+	}
+	for key, item := range src.volatileItems {
+		if k, own := src.ownKey(key); own && !item.expiredAt(nowMs) {
+			item.Object = cloneObject(item.Object)
+			items[k] = item
+		}
+	}
+	src.mtx.RUnlock()
+
+	for k, item := range items {
+		if err := c.validate(c.prefix+k, item.Object); err != nil {
+			return err
+		}
+	}
+
+	c.mtx.Lock()
+	for k, item := range items {
+		c.store(c.prefix+k, item.Object, item.ExpireMs)
+	}
+	c.unlock()
+
 	return nil
 }
 
-func (c *Cache) cleanup() {
-	nowMs := time.Now().UnixMilli()
+// WaitEmpty blocks until the cache holds no valid items, or until ctx is done
+// or the cache is closed. Expired items count as absent, whether or not the
+// cleanup has removed them yet: while a caller waits, they are removed like
+// by the cleanup as soon as no persistent item is left.
+func (c *Cache) WaitEmpty(ctx context.Context) error {
+	for {
+		c.mtx.Lock()
+		nowMs := c.nowMs()
+		if len(c.persistItems) == 0 {
+			c.removeExpired(nowMs, 0)
+		}
+		if len(c.persistItems)+len(c.volatileItems) == 0 {
+			c.unlock()
+			return nil
+		}
+		if c.emptyCh == nil {
+			c.emptyCh = make(chan struct{})
+		}
+		ch := c.emptyCh
+		// without persistent items, the cache may get empty as the volatile
+		// items expire, even if nothing removes them, so check again at the
+		// next expiration
+		nextMs := int64(-1)
+		if len(c.persistItems) == 0 && len(c.expiries) > 0 {
+			nextMs = c.expiries[0].expireMs
+		}
+		c.unlock()
+
+		var timer *time.Timer
+		var expiry <-chan time.Time
+		if nextMs >= 0 {
+			timer = time.NewTimer(time.Duration(nextMs+1-nowMs) * time.Millisecond)
+			expiry = timer.C
+		}
+
+		var err error
+		recheck := false
+		select {
+		case <-ch:
+		case <-expiry:
+			recheck = true
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-c.ctx.Done():
+			err = c.ctx.Err()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if !recheck {
+			return err
+		}
+	}
+}
+
+// notifyEmpty wakes up WaitEmpty callers if the cache holds no valid items
+// anymore. Must be called with c.mtx held after removing items.
+func (c *Cache) notifyEmpty() {
+	if c.emptyCh == nil || len(c.persistItems) > 0 {
+		return
+	}
+	// expired items count as absent; removing them pops each off the expiry
+	// index once, instead of scanning all items on every call
+	c.removeExpired(c.nowMs(), 0)
+	if len(c.volatileItems) == 0 {
+		close(c.emptyCh)
+		c.emptyCh = nil
+	}
+}
+
+// GetAndDelete returns the object of key and deletes the key in one step.
+func (c *Cache) GetAndDelete(key string) (interface{}, error) {
+	key = c.key(key)
+
 	c.mtx.Lock()
-	for key, item := range c.volatileItems {
-		if nowMs > item.ExpireMs {
-			delete(c.volatileItems, key)
-			c.changed = true
+	defer c.unlock()
+
+	return c.take(key, nil)
+}
+
+// SetDefault sets val of any type with the DefaultTTL, like SetAny.
+func (c *Cache) SetDefault(key string, val interface{}) error {
+	return SetAny(c, key, val, c.opts.defaultTTL)
+}
+
+// take removes key and returns its object, if match is nil or accepts the
+// object. Must be called with c.mtx held.
+func (c *Cache) take(key string, match func(obj interface{}) bool) (interface{}, error) {
+	item, exists := c.persistItems[key]
+	if !exists {
+		item, exists = c.volatileItems[key]
+		if !exists || c.expired(item) {
+			return nil, ErrNotExists
+		}
+	}
+
+	if match != nil && !match(item.Object) {
+		return nil, invalidTypeError(item.Object)
+	}
+
+	delete(c.persistItems, key)
+	delete(c.volatileItems, key)
+	c.stopTimer(key)
+	c.changes++
+	c.record(key, EventDel, item)
+	c.notifyEmpty()
+
+	return item.Object, nil
+}
+
+// validate checks a write against MaxKeyLen and the Validator.
+func (c *Cache) validate(key string, obj interface{}) error {
+	if c.opts.maxKeyLen > 0 && len(key) > c.opts.maxKeyLen {
+		return ErrKeyTooLong
+	}
+	if c.opts.validator != nil {
+		return c.opts.validator(key, obj)
+	}
+	return nil
+}
+
+// set stores obj under key, routing it by ttl. Must be called with c.mtx held.
+func (c *Cache) set(key string, obj interface{}, ttl time.Duration) {
+	if c.opts.copyOnSet {
+		obj = cloneObject(obj)
+	}
+
+	c.store(key, obj, c.expireMs(ttl))
+}
+
+// expireMs returns the expiration of an item written now with ttl.
+func (c *Cache) expireMs(ttl time.Duration) int64 {
+	if ttl == NEVER_EXPIRE {
+		return kNeverExpireMs
+	}
+
+	// clamp huge TTLs instead of overflowing into the past
+	nowMs := c.nowMs()
+	if ttlMs := ttl.Milliseconds(); ttlMs < kNeverExpireMs-nowMs {
+		return c.quantize(nowMs + ttlMs)
+	}
+	return kNeverExpireMs - 1
+}
+
+// quantize rounds expireMs up to the ExpiryPrecision, so items never expire
+// early.
+func (c *Cache) quantize(expireMs int64) int64 {
+	p := c.opts.expiryPrecision.Milliseconds()
+	if p <= 1 || expireMs > kNeverExpireMs-p {
+		return expireMs
+	}
+	if r := expireMs % p; r > 0 {
+		expireMs += p - r
+	}
+	return expireMs
+}
+
+// resolveTTL maps a TTL of 0, as returned by a Loader or given in an Entry,
+// to the DefaultTTL.
+func (c *Cache) resolveTTL(ttl time.Duration) time.Duration {
+	if ttl == 0 {
+		return c.opts.defaultTTL
+	}
+	return ttl
+}
+
+// checkTTL rejects negative TTLs other than NEVER_EXPIRE with ErrInvalidTTL,
+// they would store already expired items.
+func checkTTL(ttl time.Duration) error {
+	if ttl < 0 && ttl != NEVER_EXPIRE {
+		return ErrInvalidTTL
+	}
+	return nil
+}
+
+// store stores obj under key, routing it by expireMs. Must be called with
+// c.mtx held.
+func (c *Cache) store(key string, obj interface{}, expireMs int64) {
+	if old, exists := c.volatileItems[key]; exists && c.expired(old) {
+		// overwriting an expired item not cleaned up yet removes it
+		delete(c.volatileItems, key)
+		c.stopTimer(key)
+		c.record(key, EventExpired, old)
+	}
+
+	if expireMs == kNeverExpireMs {
+		delete(c.volatileItems, key)
+	} else {
+		delete(c.persistItems, key)
+	}
+	c.put(key, Item{
+		Object:   obj,
+		ExpireMs: expireMs,
+	})
+}
+
+// put writes item back to the map matching its expiration and stamps it with
+// a new version. Must be called with c.mtx held.
+func (c *Cache) put(key string, item Item) {
+	c.seq++
+	item.version = c.seq
+	if item.neverExpire() {
+		c.persistItems[key] = item
+		c.stopTimer(key)
+	} else {
+		if old, exists := c.volatileItems[key]; !exists || old.ExpireMs != item.ExpireMs {
+			c.indexExpiry(key, item.ExpireMs)
+			c.scheduleTimer(key, item.ExpireMs)
+		}
+		c.volatileItems[key] = item
+		c.touch(key, true)
+	}
+	c.changes++
+	c.record(key, EventSet, item)
+	c.evict(key)
+
+	// closing never blocks, so waking the waiters under the lock is fine
+	if w, exists := c.waiters[key]; exists {
+		close(w.ch)
+		delete(c.waiters, key)
+	}
+}
+
+// waiter is the channel closed once a key is written, shared by the n
+// GetWait calls waiting for it.
+type waiter struct {
+	ch chan struct{}
+	n  int
+}
+
+// wait returns the waiter for the next write of key, which must be released
+// by unwait unless the write came. Must be called with c.mtx held.
+func (c *Cache) wait(key string) *waiter {
+	if c.waiters == nil {
+		c.waiters = make(map[string]*waiter)
+	}
+
+	w, exists := c.waiters[key]
+	if !exists {
+		w = &waiter{ch: make(chan struct{})}
+		c.waiters[key] = w
+	}
+	w.n++
+	return w
+}
+
+// unwait releases w of a caller giving up, dropping it once no one waits
+// for key anymore.
+func (c *Cache) unwait(key string, w *waiter) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	w.n--
+	if w.n == 0 && c.waiters[key] == w {
+		delete(c.waiters, key)
+	}
+}
+
+// CloseOnSignal closes the cache, flushing it, when one of sigs arrives, by
+// default os.Interrupt or SIGTERM. It stops listening once the cache is
+// closed. As the signals are caught, they no longer end the process, which
+// must exit on its own, e.g. once Close returns.
+func (c *Cache) CloseOnSignal(sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		// signal.Notify relays all signals without any, including those the
+		// runtime uses
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	go func() {
+		defer signal.Stop(ch)
+
+		select {
+		case <-ch:
+			c.Close()
+		case <-c.ctx.Done():
 		}
+	}()
+}
+
+// cleanup removes the expired items, in batches of CleanupBatchSize if set.
+func (c *Cache) cleanup() {
+	nowMs := c.nowMs()
+	for {
+		c.mtx.Lock()
+		n := c.removeExpired(nowMs, c.opts.cleanupBatchSize)
+		c.notifyEmpty()
+		c.unlock()
+
+		if c.opts.cleanupBatchSize <= 0 || n < c.opts.cleanupBatchSize {
+			return
+		}
+	}
+}
+
+// removeExpired removes up to limit items expired at nowMs, all of them if
+// limit isn't positive, and returns their number. Must be called with c.mtx
+// held.
+func (c *Cache) removeExpired(nowMs int64, limit int) int {
+	n := 0
+	for limit <= 0 || n < limit {
+		key, item, found := c.popExpired(nowMs)
+		if !found {
+			break
+		}
+		delete(c.volatileItems, key)
+		c.stopTimer(key)
+		c.changes++
+		c.record(key, EventExpired, item)
+		n++
 	}
-	c.mtx.Unlock()
+	return n
 }
 
-func (c *Cache) persist() {
+// persist flushes the buffered writes of WriteBehind, then saves the items.
+func (c *Cache) persist(force bool) error {
+	var flushErr error
+	if c.opts.writeBehind {
+		flushErr = c.flushWrites()
+	}
+
+	if err := c.save(force); err != nil {
+		return err
+	}
+	return flushErr
+}
+
+// save saves the items if anything changed, or on the periodic persist if at
+// least MinChangesToPersist changes accumulated. A PartialPersister is only
+// handed the dirty keys. Saves never overlap, so a slow Save can't be
+// overtaken by a newer snapshot: the periodic persist is skipped while one is
+// running, the pending changes are saved on the next tick, and forced ones
+// wait for it.
+func (c *Cache) save(force bool) error {
 	if c.persister == nil {
-		return
+		return nil
 	}
 
+	if force {
+		c.saveMtx.Lock()
+	} else if !c.saveMtx.TryLock() {
+		return nil
+	}
+	defer c.saveMtx.Unlock()
+
 	changed := false
 	items := make(map[string]Item)
-	c.mtx.RLock()
-	if c.changed {
+	var deleted []string
+	var dirty map[string]struct{}
+	var changes int
+	c.mtx.Lock()
+	if c.changes > 0 && (force || c.changes >= c.opts.minChangesToPersist) {
 		changed = true
 
-		for key, item := range c.persistItems {
-			items[key] = item
+		if c.oplog != nil {
+			c.oplog.Checkpoint()
 		}
 
-		for key, item := range c.volatileItems {
-			if !item.expired() {
+		minExpireMs := c.clock.Now().Add(c.opts.minPersistTTL).UnixMilli()
+		keep := func(item Item) bool {
+			return item.neverExpire() || (item.ExpireMs >= minExpireMs && !c.expired(item))
+		}
+
+		if c.partial != nil {
+			dirty = c.dirty
+			c.dirty = make(map[string]struct{})
+			for key := range dirty {
+				item, exists := c.persistItems[key]
+				if !exists {
+					item, exists = c.volatileItems[key]
+				}
+				if exists && keep(item) {
+					items[key] = item
+				} else {
+					deleted = append(deleted, key)
+				}
+			}
+		} else {
+			for key, item := range c.persistItems {
 				items[key] = item
 			}
+			for key, item := range c.volatileItems {
+				if keep(item) {
+					items[key] = item
+				}
+			}
 		}
-		c.changed = false
+		changes = c.changes
+		c.changes = 0
+	}
+	c.unlock()
+
+	if !changed {
+		return nil
 	}
-	c.mtx.RUnlock()
 
-	if changed {
-		c.persister.Save(items)
+	var err error
+	if c.partial == nil {
+		err = c.persister.Save(items)
+	} else {
+		err = c.partial.SavePartial(items, deleted)
 	}
+
+	if err == nil {
+		atomic.StoreInt64(&c.lastPersistNs, c.clock.Now().UnixNano())
+	}
+
+	c.mtx.Lock()
+	c.persistErr = err
+	if err != nil {
+		// keep the changes pending so the next persist retries
+		c.changes += changes
+		for key := range dirty {
+			c.dirty[key] = struct{}{}
+		}
+	}
+	c.unlock()
+
+	if err != nil && c.opts.onPersistError != nil {
+		c.opts.onPersistError(err)
+	}
+
+	return err
 }
@@ -2,6 +2,12 @@ package gcache
 
 import (
 	"context"
+	"errors"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -41,6 +47,7 @@ func TestPersist(t *testing.T) {
 	defer cancel()
 
 	persister := &FilePersister{FilePath: "persist.bin"}
+	defer os.Remove(persister.FilePath)
 	c, err := New(ctx, time.Second*2, time.Second*3, persister)
 	if err != nil {
 		t.Error("create cache error:", err)
@@ -97,3 +104,1349 @@ func TestPersist(t *testing.T) {
 		return
 	}
 }
+
+func TestCloseFlush(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	persister := &FilePersister{FilePath: "persist_close.bin"}
+	defer os.Remove(persister.FilePath)
+
+	c, err := New(ctx, time.Second*2, time.Hour, persister)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+	c.Close()
+
+	c2, err := New(ctx, time.Second*2, time.Hour, persister)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c2.Close()
+
+	v1, err := Get[string](c2, "k1")
+	if err != nil {
+		t.Error(err)
+		return
+	} else if v1 != "v1" {
+		t.Errorf("value error. key: k1, expect: v1, got: %v", v1)
+		return
+	}
+}
+
+type failPersister struct{}
+
+func (p *failPersister) Load() (map[string]Item, error) {
+	return make(map[string]Item), nil
+}
+
+func (p *failPersister) Save(items map[string]Item) error {
+	return errors.New("disk full")
+}
+
+func TestCloseIdempotent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, time.Second*2, time.Hour, &failPersister{})
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+	if err := c.Close(); err == nil {
+		t.Error("expect error from the final persist")
+		return
+	}
+
+	if err := c.Close(); err == nil {
+		t.Error("expect the same error from the second Close")
+		return
+	}
+}
+
+func TestNewFromMap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	data := map[string]interface{}{
+		"k1": "v1",
+		"k2": int64(2),
+		"k3": []string{"a", "b"},
+	}
+
+	c, err := NewFromMap(ctx, data, NEVER_EXPIRE)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	if n := Len(c); n != len(data) {
+		t.Errorf("invalid number of items, expect: %v, got: %v", len(data), n)
+		return
+	}
+
+	v2, err := Get[int64](c, "k2")
+	if err != nil {
+		t.Error(err)
+		return
+	} else if v2 != 2 {
+		t.Errorf("value error. key: k2, expect: 2, got: %v", v2)
+		return
+	}
+
+	persister := &FilePersister{FilePath: "persist_from_map.bin"}
+	defer os.Remove(persister.FilePath)
+
+	c2, err := NewFromMap(ctx, data, time.Minute, WithPersister(persister))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c2.Close()
+
+	for key := range data {
+		if ttl, err := GetTTL(c2, key); err != nil {
+			t.Error(err)
+			return
+		} else if ttl <= 0 || ttl > time.Minute {
+			t.Errorf("ttl error. key: %v, got: %v", key, ttl)
+			return
+		}
+	}
+}
+
+func TestFlush(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	persister := &FilePersister{FilePath: "persist_flush.bin"}
+	defer os.Remove(persister.FilePath)
+
+	c, err := New(ctx, time.Second*2, time.Hour, persister)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+	if err := c.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	items, err := persister.Load()
+	if err != nil {
+		t.Error(err)
+		return
+	} else if _, exists := items["k1"]; !exists {
+		t.Error("key k1 not flushed")
+		return
+	}
+
+	if err := c.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestClear(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, time.Second*2, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+	Set(c, "k2", "v2", time.Minute)
+	c.Clear()
+
+	if n := Len(c); n != 0 {
+		t.Errorf("invalid number of items, expect: 0, got: %v", n)
+		return
+	}
+	if Exists(c, "k1") || Exists(c, "k2") {
+		t.Error("keys exist after clear")
+		return
+	}
+}
+
+func TestSetInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	persister := &FilePersister{FilePath: "persist_interval.bin"}
+	defer os.Remove(persister.FilePath)
+
+	c, err := New(ctx, time.Hour, time.Hour, persister)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	c.SetCleanupInterval(time.Millisecond * 100)
+	c.SetPersistInterval(time.Millisecond * 100)
+
+	Set(c, "k1", "v1", time.Millisecond*50)
+	Set(c, "k2", "v2", NEVER_EXPIRE)
+	time.Sleep(time.Millisecond * 500)
+
+	if n := Len(c); n != 1 {
+		t.Errorf("expired item not cleaned up, expect: 1, got: %v", n)
+		return
+	}
+
+	items, err := persister.Load()
+	if err != nil {
+		t.Error(err)
+		return
+	} else if _, exists := items["k2"]; !exists {
+		t.Error("key k2 not persisted")
+		return
+	}
+}
+
+func TestZeroInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+
+	Set(c, "k1", "v1", time.Millisecond*10)
+	time.Sleep(time.Millisecond * 50)
+	if n := Len(c); n != 1 {
+		t.Errorf("cleanup not disabled, expect: 1, got: %v", n)
+		return
+	}
+
+	c.SetCleanupInterval(time.Millisecond * 10)
+	time.Sleep(time.Millisecond * 50)
+	if n := Len(c); n != 0 {
+		t.Errorf("cleanup not enabled, expect: 0, got: %v", n)
+		return
+	}
+	c.SetCleanupInterval(-1)
+	c.Close()
+
+	persister := &FilePersister{FilePath: "persist_zero.bin"}
+	defer os.Remove(persister.FilePath)
+
+	c2, err := New(ctx, 0, time.Millisecond*10, persister)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c2.Close()
+
+	Set(c2, "k1", "v1", NEVER_EXPIRE)
+	time.Sleep(time.Millisecond * 50)
+
+	items, err := persister.Load()
+	if err != nil {
+		t.Error(err)
+		return
+	} else if _, exists := items["k1"]; !exists {
+		t.Error("key k1 not persisted")
+		return
+	}
+}
+
+func TestWaitEmpty(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, time.Millisecond*10, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	if err := c.WaitEmpty(ctx); err != nil {
+		t.Error(err)
+		return
+	}
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+	Set(c, "k2", "v2", time.Millisecond*50)
+
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		Delete(c, "k1")
+	}()
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, time.Second)
+	defer waitCancel()
+
+	start := time.Now()
+	if err := c.WaitEmpty(waitCtx); err != nil {
+		t.Error(err)
+		return
+	}
+	if elapsed := time.Since(start); elapsed > time.Millisecond*500 {
+		t.Errorf("WaitEmpty returned late: %v", elapsed)
+		return
+	}
+
+	Set(c, "k3", "v3", NEVER_EXPIRE)
+	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, time.Millisecond*20)
+	defer timeoutCancel()
+
+	if err := c.WaitEmpty(timeoutCtx); err != context.DeadlineExceeded {
+		t.Errorf("expect: %v, got: %v", context.DeadlineExceeded, err)
+		return
+	}
+}
+
+func TestWaitEmptyWithoutCleanup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	// expired items left behind count as absent
+	Set(c, "k1", "v1", time.Millisecond*10)
+	Set(c, "k2", "v2", time.Millisecond*30)
+	time.Sleep(time.Millisecond * 20)
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, time.Second)
+	defer waitCancel()
+
+	if err := c.WaitEmpty(waitCtx); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// deleting the last valid item wakes up the waiters despite expired ones
+	Set(c, "k3", "v3", time.Millisecond)
+	Set(c, "k4", "v4", NEVER_EXPIRE)
+	time.Sleep(time.Millisecond * 5)
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		Delete(c, "k4")
+	}()
+
+	if err := c.WaitEmpty(waitCtx); err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestExpiryTimers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil, WithExpiryTimers())
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", "v1", time.Millisecond*20)
+	Set(c, "k2", "v2", time.Millisecond*20)
+	Set(c, "k2", "v2", time.Minute)
+	Set(c, "k3", "v3", time.Minute)
+	Delete(c, "k3")
+	time.Sleep(time.Millisecond * 100)
+
+	if n := Len(c); n != 1 {
+		t.Errorf("invalid number of items, expect: 1, got: %v", n)
+		return
+	}
+	if !Exists(c, "k2") {
+		t.Error("rescheduled key k2 removed")
+		return
+	}
+
+	c.mtx.RLock()
+	n := len(c.timers)
+	c.mtx.RUnlock()
+	if n != 1 {
+		t.Errorf("invalid number of timers, expect: 1, got: %v", n)
+		return
+	}
+}
+
+func TestMinPersistTTL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	persister := &FilePersister{FilePath: "persist_min_ttl.bin"}
+	defer os.Remove(persister.FilePath)
+
+	c, err := New(ctx, 0, 0, persister, WithMinPersistTTL(time.Minute))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", "v1", time.Second*2)
+	Set(c, "k2", "v2", time.Hour)
+	Set(c, "k3", "v3", NEVER_EXPIRE)
+	if err := c.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	items, err := persister.Load()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, exists := items["k1"]; exists {
+		t.Error("short-TTL key k1 persisted")
+		return
+	}
+	if _, exists := items["k2"]; !exists {
+		t.Error("long-TTL key k2 not persisted")
+		return
+	}
+	if _, exists := items["k3"]; !exists {
+		t.Error("persist key k3 not persisted")
+		return
+	}
+}
+
+func TestCopyOnSet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil, WithCopyOnSet())
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	s := []string{"a", "b"}
+	m := map[string]int{"a": 1}
+	Set(c, "k1", s, NEVER_EXPIRE)
+	SetAt(c, "k2", m, time.Time{})
+	s[0] = "x"
+	m["a"] = 2
+
+	if v, _ := SliceIndex[string](c, "k1", 0); v != "a" {
+		t.Errorf("slice not copied, expect: a, got: %v", v)
+		return
+	}
+	if v, _ := Get[map[string]int](c, "k2"); v["a"] != 1 {
+		t.Errorf("map not copied, expect: 1, got: %v", v["a"])
+		return
+	}
+}
+
+func TestMinChangesToPersist(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	persister := &FilePersister{FilePath: "persist_min_changes.bin"}
+	defer os.Remove(persister.FilePath)
+
+	c, err := New(ctx, 0, time.Millisecond*10, persister, WithMinChangesToPersist(3))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+	Set(c, "k2", "v2", NEVER_EXPIRE)
+	time.Sleep(time.Millisecond * 50)
+
+	if items, err := persister.Load(); err != nil {
+		t.Error(err)
+		return
+	} else if len(items) != 0 {
+		t.Errorf("persisted before enough changes, got: %v", items)
+		return
+	}
+
+	Set(c, "k3", "v3", NEVER_EXPIRE)
+	time.Sleep(time.Millisecond * 50)
+
+	if items, err := persister.Load(); err != nil {
+		t.Error(err)
+		return
+	} else if len(items) != 3 {
+		t.Errorf("invalid number of persisted items, expect: 3, got: %v", len(items))
+		return
+	}
+
+	Set(c, "k4", "v4", NEVER_EXPIRE)
+	c.Close()
+
+	if items, err := persister.Load(); err != nil {
+		t.Error(err)
+		return
+	} else if len(items) != 4 {
+		t.Errorf("not persisted on close, expect: 4, got: %v", len(items))
+		return
+	}
+}
+
+type partialPersister struct {
+	changed map[string]Item
+	deleted []string
+	err     error
+}
+
+func (p *partialPersister) Load() (map[string]Item, error) {
+	return map[string]Item{
+		"k0": {Object: "v0", ExpireMs: kNeverExpireMs},
+	}, nil
+}
+
+func (p *partialPersister) Save(items map[string]Item) error {
+	return errors.New("full save of a partial persister")
+}
+
+func (p *partialPersister) SavePartial(changed map[string]Item, deleted []string) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.changed = changed
+	p.deleted = deleted
+	return nil
+}
+
+func TestSavePartial(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	persister := &partialPersister{}
+	c, err := New(ctx, 0, 0, persister)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+	Delete(c, "k0")
+
+	persister.err = errors.New("unreachable")
+	if err := c.Flush(); err == nil {
+		t.Error("expect error from SavePartial")
+		return
+	}
+	persister.err = nil
+
+	Set(c, "k2", "v2", time.Minute)
+	if err := c.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(persister.changed) != 2 || persister.changed["k1"].Object != "v1" || persister.changed["k2"].Object != "v2" {
+		t.Errorf("changed items error, expect: k1, k2, got: %v", persister.changed)
+		return
+	}
+	if len(persister.deleted) != 1 || persister.deleted[0] != "k0" {
+		t.Errorf("deleted keys error, expect: [k0], got: %v", persister.deleted)
+		return
+	}
+
+	Set(c, "k3", "v3", NEVER_EXPIRE)
+	if err := c.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(persister.changed) != 1 || len(persister.deleted) != 0 {
+		t.Errorf("dirty keys not cleared, got: %v, %v", persister.changed, persister.deleted)
+		return
+	}
+}
+
+func TestLastPersistError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, time.Millisecond*10, &failPersister{})
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	if err := c.LastPersistError(); err != nil {
+		t.Errorf("expect no error before any persist, got: %v", err)
+		return
+	}
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+	time.Sleep(time.Millisecond * 50)
+
+	if err := c.LastPersistError(); err == nil {
+		t.Error("expect error from the periodic persist")
+		return
+	}
+}
+
+func TestTypeCheck(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type unregistered struct{ A int }
+
+	c, err := New(ctx, 0, 0, nil, WithTypeCheck())
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	if err := SetAny(c, "k1", unregistered{1}, NEVER_EXPIRE); !errors.Is(err, ErrInvalidType) {
+		t.Errorf("unregistered type, expect: %v, got: %v", ErrInvalidType, err)
+		return
+	}
+	if Exists(c, "k1") {
+		t.Error("rejected value stored")
+		return
+	}
+	if err := SetAny(c, "k2", []float32{1}, NEVER_EXPIRE); err != nil {
+		t.Error(err)
+		return
+	}
+
+	data := map[string]interface{}{"k1": unregistered{1}}
+	if _, err := NewFromMap(ctx, data, NEVER_EXPIRE, WithTypeCheck()); !errors.Is(err, ErrInvalidType) {
+		t.Errorf("unregistered type, expect: %v, got: %v", ErrInvalidType, err)
+		return
+	}
+}
+
+type flakyPersister struct {
+	mtx   sync.Mutex
+	fails int
+	saved map[string]Item
+}
+
+func (p *flakyPersister) Load() (map[string]Item, error) {
+	return make(map[string]Item), nil
+}
+
+func (p *flakyPersister) Save(items map[string]Item) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.fails > 0 {
+		p.fails--
+		return errors.New("disk full")
+	}
+	p.saved = items
+	return nil
+}
+
+func TestOnPersistError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var errs int32
+	persister := &flakyPersister{fails: 2}
+	c, err := New(ctx, 0, time.Millisecond*10, persister, WithOnPersistError(func(err error) {
+		atomic.AddInt32(&errs, 1)
+	}))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+	time.Sleep(time.Millisecond * 100)
+
+	if n := atomic.LoadInt32(&errs); n != 2 {
+		t.Errorf("invalid number of persist errors, expect: 2, got: %v", n)
+		return
+	}
+
+	persister.mtx.Lock()
+	_, saved := persister.saved["k1"]
+	persister.mtx.Unlock()
+	if !saved {
+		t.Error("failed persist not retried")
+		return
+	}
+	if err := c.LastPersistError(); err != nil {
+		t.Errorf("expect no error after the retry, got: %v", err)
+		return
+	}
+}
+
+func TestLastPersistTime(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := &fakeClock{now: time.UnixMilli(10000)}
+	persister := &flakyPersister{fails: 1}
+	c, err := NewWithClock(ctx, 0, 0, persister, clock)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	if at := c.LastPersistTime(); !at.IsZero() {
+		t.Errorf("expect zero time before persisting, got: %v", at)
+		return
+	}
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+	if err := c.Flush(); err == nil {
+		t.Error("expect persist error")
+		return
+	}
+	if at := c.LastPersistTime(); !at.IsZero() {
+		t.Errorf("failed persist recorded, got: %v", at)
+		return
+	}
+
+	clock.Advance(time.Second)
+	if err := c.Flush(); err != nil {
+		t.Error("flush error:", err)
+		return
+	}
+	if at := c.LastPersistTime(); !at.Equal(time.UnixMilli(11000)) {
+		t.Errorf("invalid last persist time, expect: %v, got: %v", time.UnixMilli(11000), at)
+		return
+	}
+}
+
+type slowPersister struct {
+	active    int32
+	overlaps  int32
+	saves     int32
+	saveDelay time.Duration
+}
+
+func (p *slowPersister) Load() (map[string]Item, error) {
+	return make(map[string]Item), nil
+}
+
+func (p *slowPersister) Save(items map[string]Item) error {
+	if atomic.AddInt32(&p.active, 1) > 1 {
+		atomic.AddInt32(&p.overlaps, 1)
+	}
+	time.Sleep(p.saveDelay)
+	atomic.AddInt32(&p.active, -1)
+	atomic.AddInt32(&p.saves, 1)
+	return nil
+}
+
+func TestSaveNoOverlap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	persister := &slowPersister{saveDelay: time.Millisecond * 20}
+	c, err := New(ctx, 0, time.Millisecond, persister)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 3; j++ {
+				Set(c, BuildKey("k", i, j), j, NEVER_EXPIRE)
+				if err := c.Flush(); err != nil {
+					t.Error("flush error:", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	c.Close()
+
+	if n := atomic.LoadInt32(&persister.overlaps); n != 0 {
+		t.Errorf("saves overlapped %v times", n)
+		return
+	}
+	if n := atomic.LoadInt32(&persister.saves); n == 0 {
+		t.Error("nothing saved")
+		return
+	}
+}
+
+type fakeClock struct {
+	mtx sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mtx.Lock()
+	c.now = c.now.Add(d)
+	c.mtx.Unlock()
+}
+
+func TestClock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := &fakeClock{now: time.Now()}
+	c, err := NewWithClock(ctx, 0, 0, nil, clock)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", "v1", time.Hour)
+	clock.Advance(time.Minute * 30)
+
+	if ttl, err := GetTTL(c, "k1"); err != nil {
+		t.Error(err)
+		return
+	} else if ttl != time.Minute*30 {
+		t.Errorf("ttl error. key: k1, expect: 30m, got: %v", ttl)
+		return
+	}
+
+	clock.Advance(time.Minute * 31)
+	if _, err := Get[string](c, "k1"); err != ErrNotExists {
+		t.Errorf("not expired, expect: %v, got: %v", ErrNotExists, err)
+		return
+	}
+
+	c.cleanup()
+	if n := Len(c); n != 0 {
+		t.Errorf("expired item not cleaned up, expect: 0, got: %v", n)
+		return
+	}
+}
+
+func TestCloseOnSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("can't send signals to self on windows")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	persister := &FilePersister{FilePath: "persist_signal.bin"}
+	defer os.Remove(persister.FilePath)
+
+	c, err := New(ctx, 0, 0, persister)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	c.CloseOnSignal(os.Interrupt)
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+
+	p, _ := os.FindProcess(os.Getpid())
+	if err := p.Signal(os.Interrupt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	select {
+	case <-c.ctx.Done():
+	case <-time.After(time.Second):
+		t.Error("cache not closed on signal")
+		return
+	}
+	c.Close()
+
+	if items, err := persister.Load(); err != nil {
+		t.Error(err)
+		return
+	} else if _, exists := items["k1"]; !exists {
+		t.Error("key k1 not flushed on signal")
+		return
+	}
+}
+
+func TestCloseOnDefaultSignals(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("can't send signals to self on windows")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+	c.CloseOnSignal()
+
+	// signals other than the defaults, e.g. those of the runtime, are ignored
+	time.Sleep(time.Millisecond * 50)
+	if c.ctx.Err() != nil {
+		t.Error("cache closed without signal")
+		return
+	}
+
+	p, _ := os.FindProcess(os.Getpid())
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		t.Error(err)
+		return
+	}
+
+	select {
+	case <-c.ctx.Done():
+	case <-time.After(time.Second):
+		t.Error("cache not closed on SIGTERM")
+		return
+	}
+}
+
+func TestLoader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls int32
+	release := make(chan struct{})
+	loader := func(key string) (interface{}, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		if key == "missing" {
+			return nil, 0, ErrNotExists
+		}
+		return "loaded:" + key, time.Minute, nil
+	}
+
+	c, err := New(ctx, 0, 0, nil, WithLoader(loader))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := Get[string](c, "k1"); err != nil || v != "loaded:k1" {
+				t.Errorf("get error, expect: loaded:k1, got: %v, %v", v, err)
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond * 20)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("invalid number of loads, expect: 1, got: %v", n)
+		return
+	}
+
+	if ttl, err := GetTTL(c, "k1"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("loaded value not stored with its ttl, got: %v, %v", ttl, err)
+		return
+	}
+
+	if _, err := Get[string](c, "missing"); err != ErrNotExists {
+		t.Errorf("loader error not returned, got: %v", err)
+		return
+	}
+	if Exists(c, "missing") {
+		t.Error("failed load stored a value")
+		return
+	}
+
+	// the first load blocked for at least 20ms
+	stats := c.Stats()
+	if stats.LoaderCalls != 2 || stats.LoaderErrors != 1 || stats.AvgLoaderTime() < time.Millisecond*10 {
+		t.Errorf("invalid loader stats, got: %+v", stats)
+		return
+	}
+}
+
+func TestWriter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errWrite := errors.New("write error")
+	var mtx sync.Mutex
+	store := make(map[string]interface{})
+	writer := func(key string, val interface{}, ttl time.Duration) error {
+		if key == "bad" {
+			return errWrite
+		}
+		mtx.Lock()
+		store[key] = val
+		mtx.Unlock()
+		return nil
+	}
+
+	c, err := New(ctx, 0, 0, nil, WithWriter(writer))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", "v1", time.Minute)
+	if store["k1"] != "v1" {
+		t.Errorf("write not propagated, got: %v", store["k1"])
+		return
+	}
+
+	if err := SetChecked(c, "bad", "v", time.Minute); err != errWrite {
+		t.Errorf("writer error not returned, got: %v", err)
+		return
+	}
+	if Exists(c, "bad") {
+		t.Error("value stored although the writer failed")
+		return
+	}
+}
+
+func TestWriterConcurrentWrites(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mtx sync.Mutex
+	store := make(map[string]interface{})
+	writer := func(key string, val interface{}, ttl time.Duration) error {
+		mtx.Lock()
+		store[key] = val
+		mtx.Unlock()
+		// delay storing some values in the cache after the write
+		if val.(int)%2 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		return nil
+	}
+
+	c, err := New(ctx, 0, 0, nil, WithWriter(writer))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	for round := 0; round < 100; round++ {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				switch i % 3 {
+				case 0:
+					Set(c, "k1", i, NEVER_EXPIRE)
+				case 1:
+					SetAny(c, "k1", i, NEVER_EXPIRE)
+				default:
+					SetManyTTL(c, map[string]Entry[int]{"k1": {Val: i, TTL: NEVER_EXPIRE}})
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		v, _ := Get[int](c, "k1")
+		mtx.Lock()
+		stored := store["k1"]
+		mtx.Unlock()
+		if stored != v {
+			t.Errorf("cache and store differ, cache: %v, store: %v", v, stored)
+			return
+		}
+	}
+}
+
+func TestWriteBehind(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mtx sync.Mutex
+	fail := true
+	store := make(map[string]interface{})
+	calls := make(map[string]int)
+	writer := func(key string, val interface{}, ttl time.Duration) error {
+		mtx.Lock()
+		defer mtx.Unlock()
+		calls[key]++
+		if key == "k2" && fail {
+			return errors.New("write error")
+		}
+		store[key] = val
+		return nil
+	}
+
+	c, err := New(ctx, 0, time.Hour, nil, WithWriter(writer), WithWriteBehind())
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", 1, time.Minute)
+	Set(c, "k1", 2, time.Minute)
+	Set(c, "k2", 3, time.Minute)
+	if len(store) != 0 {
+		t.Error("write not buffered")
+		return
+	}
+
+	if err := c.Flush(); err == nil {
+		t.Error("writer error not returned")
+		return
+	}
+	if store["k1"] != 2 || calls["k1"] != 1 {
+		t.Errorf("writes not coalesced, got: %v after %v calls", store["k1"], calls["k1"])
+		return
+	}
+
+	mtx.Lock()
+	fail = false
+	mtx.Unlock()
+	if err := c.Flush(); err != nil {
+		t.Error("flush error:", err)
+		return
+	}
+	if store["k2"] != 3 {
+		t.Errorf("failed write not retried, got: %v", store["k2"])
+		return
+	}
+}
+
+func TestCopyFrom(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer src.Close()
+
+	Set(src, "k1", "v1", NEVER_EXPIRE)
+	Set(src, "k2", []int{1, 2}, time.Minute)
+	Set(src, "k3", 3, time.Millisecond)
+	time.Sleep(time.Millisecond * 5)
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	if err := c.CopyFrom(src); err != nil {
+		t.Error("copy error:", err)
+		return
+	}
+	if Len(c) != 2 || Exists(c, "k3") {
+		t.Errorf("invalid items copied, got: %v", Keys(c))
+		return
+	}
+	if p, _ := IsPersistent(c, "k1"); !p {
+		t.Error("persistent item copied as volatile")
+		return
+	}
+	if ttl, err := GetTTL(c, "k2"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("ttl not kept, got: %v, %v", ttl, err)
+		return
+	}
+
+	AppendToSlice(src, "k2", 3)
+	if v, _ := Get[[]int](c, "k2"); len(v) != 2 {
+		t.Errorf("slice value not copied, got: %v", v)
+		return
+	}
+}
+
+func TestPersistBacklog(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mtx sync.Mutex
+	fail := false
+	writer := func(key string, val interface{}, ttl time.Duration) error {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if fail {
+			return errors.New("write error")
+		}
+		return nil
+	}
+
+	c, err := New(ctx, 0, time.Hour, nil, WithWriter(writer), WithWriteBehind(), WithMaxPendingBytes(20))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	SetChecked(c, "k1", "abcdefgh", time.Minute)
+	SetChecked(c, "k2", "abcdefgh", time.Minute)
+	if n := c.PendingPersistBytes(); n != 20 {
+		t.Errorf("invalid pending bytes, expect: 20, got: %v", n)
+		return
+	}
+	if err := SetChecked(c, "k3", "abcdefgh", time.Minute); err != ErrPersistBacklog {
+		t.Errorf("expect ErrPersistBacklog, got: %v", err)
+		return
+	}
+	if err := SetChecked(c, "k1", "hgfedcba", time.Minute); err != nil {
+		t.Error("coalesced write rejected:", err)
+		return
+	}
+
+	mtx.Lock()
+	fail = true
+	mtx.Unlock()
+	c.Flush()
+	if n := c.PendingPersistBytes(); n != 20 {
+		t.Errorf("failed writes not pending, expect: 20, got: %v", n)
+		return
+	}
+
+	mtx.Lock()
+	fail = false
+	mtx.Unlock()
+	if err := c.Flush(); err != nil {
+		t.Error("flush error:", err)
+		return
+	}
+	if n := c.PendingPersistBytes(); n != 0 {
+		t.Errorf("invalid pending bytes, expect: 0, got: %v", n)
+		return
+	}
+	if err := SetChecked(c, "k3", "abcdefgh", time.Minute); err != nil {
+		t.Error("set error:", err)
+		return
+	}
+
+	// a write larger than the bound waits for the pending ones, then goes alone
+	if err := SetChecked(c, "k4", "abcdefghijklmnopqrstuvwxyz", time.Minute); err != ErrPersistBacklog {
+		t.Errorf("expect ErrPersistBacklog, got: %v", err)
+		return
+	}
+	if err := c.Flush(); err != nil {
+		t.Error("flush error:", err)
+		return
+	}
+	if err := SetChecked(c, "k4", "abcdefghijklmnopqrstuvwxyz", time.Minute); err != nil {
+		t.Error("large write rejected with nothing pending:", err)
+		return
+	}
+}
+
+func TestWithLock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "src", "v1", NEVER_EXPIRE)
+	Set(c, "dst", "v2", NEVER_EXPIRE)
+
+	// move src to dst only if dst is absent
+	move := func(tx *Tx) {
+		if _, exists := tx.Get("dst"); exists {
+			return
+		}
+		if v, exists := tx.Get("src"); exists {
+			tx.Del("src")
+			tx.Set("dst", v, time.Minute)
+		}
+	}
+
+	c.WithLock(move)
+	if v, _ := Get[string](c, "src"); v != "v1" {
+		t.Errorf("src moved onto an existing dst, got: %v", v)
+		return
+	}
+
+	Delete(c, "dst")
+	c.WithLock(move)
+	if Exists(c, "src") {
+		t.Error("src not deleted")
+		return
+	}
+	if v, _ := Get[string](c, "dst"); v != "v1" {
+		t.Errorf("invalid dst, expect: v1, got: %v", v)
+		return
+	}
+	if ttl, _ := GetTTL(c, "dst"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("invalid ttl, got: %v", ttl)
+		return
+	}
+
+	// keys are namespaced like the rest of the API
+	ns := c.Namespace("ns")
+	ns.WithLock(func(tx *Tx) {
+		tx.Set("k", 1, NEVER_EXPIRE)
+	})
+	if !Exists(c, "ns:k") {
+		t.Error("namespace not applied")
+		return
+	}
+}
+
+func TestLoaderZeroTTL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls int32
+	loader := func(key string) (interface{}, time.Duration, error) {
+		atomic.AddInt32(&calls, 1)
+		return "loaded:" + key, 0, nil
+	}
+
+	c, err := New(ctx, 0, 0, nil, WithLoader(loader), WithDefaultTTL(time.Hour))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Get[string](c, "k1")
+	if v, err := Get[string](c, "k1"); err != nil || v != "loaded:k1" {
+		t.Errorf("get error, expect: loaded:k1, got: %v, %v", v, err)
+		return
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("value not retained, expect: 1 load, got: %v", n)
+		return
+	}
+	if ttl, err := GetTTL(c, "k1"); err != nil || ttl <= time.Minute || ttl > time.Hour {
+		t.Errorf("expect the default ttl, got: %v, %v", ttl, err)
+		return
+	}
+}
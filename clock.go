@@ -0,0 +1,25 @@
+package gcache
+
+import "time"
+
+// Clock tells the time to the cache, e.g. a fake clock lets tests advance
+// time instantly instead of sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// nowMs returns the current time of the cache clock in ms.
+func (c *Cache) nowMs() int64 {
+	return c.clock.Now().UnixMilli()
+}
+
+// expired tells if item is expired by the cache clock.
+func (c *Cache) expired(item Item) bool {
+	return item.expiredAt(c.nowMs())
+}
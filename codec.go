@@ -0,0 +1,66 @@
+package gcache
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// Codec serializes the items for persistence.
+type Codec interface {
+	Marshal(items map[string]Item) ([]byte, error)
+	Unmarshal(data []byte) (map[string]Item, error)
+}
+
+// GobCodec encodes the items with encoding/gob, custom types must be
+// registered with RegisterType.
+type GobCodec struct{}
+
+func (GobCodec) Marshal(items map[string]Item) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&items); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// newRecordEncoder encodes the items of a file with one gob stream, so the
+// type information is written once per file rather than once per item.
+func (GobCodec) newRecordEncoder() recordEncoder {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	return func(key string, item Item) ([]byte, error) {
+		buf.Reset()
+		if err := enc.Encode(&item); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+// newRecordDecoder decodes the records of newRecordEncoder. Each record is
+// fed to the decoder alone, so a record which can't be decoded doesn't fail
+// the next ones, but those using the types first sent in it.
+func (GobCodec) newRecordDecoder() recordDecoder {
+	r := bytes.NewReader(nil)
+	dec := gob.NewDecoder(r)
+	return func(key string, encoded []byte) (Item, error) {
+		r.Reset(encoded)
+		var item Item
+		if err := dec.Decode(&item); err != nil {
+			return Item{}, err
+		}
+		return item, nil
+	}
+}
+
+func (GobCodec) Unmarshal(data []byte) (map[string]Item, error) {
+	// gob sizes a nil map by the encoded count
+	var items map[string]Item
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&items); err != nil {
+		return nil, err
+	}
+	if items == nil {
+		items = make(map[string]Item)
+	}
+	return items, nil
+}
@@ -0,0 +1,149 @@
+package gcache
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGobCodec(t *testing.T) {
+	items := map[string]Item{
+		"k1": {Object: "v1", ExpireMs: kNeverExpireMs},
+		"k2": {Object: []int{1, 2}, ExpireMs: 100},
+		"k3": {Object: map[string]float64{"a": 1.5}, ExpireMs: 200},
+	}
+
+	var codec GobCodec
+	data, err := codec.Marshal(items)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	decoded, err := codec.Unmarshal(data)
+	if err != nil {
+		t.Error(err)
+		return
+	} else if len(decoded) != len(items) {
+		t.Errorf("invalid number of items, expect: %v, got: %v", len(items), len(decoded))
+		return
+	}
+
+	if item := decoded["k1"]; item.Object != "v1" || item.ExpireMs != kNeverExpireMs {
+		t.Errorf("item error. key: k1, got: %v", item)
+		return
+	}
+	if v := decoded["k2"].Object.([]int); len(v) != 2 || v[1] != 2 {
+		t.Errorf("value error. key: k2, expect: [1 2], got: %v", v)
+		return
+	}
+	if v := decoded["k3"].Object.(map[string]float64); v["a"] != 1.5 {
+		t.Errorf("value error. key: k3, expect: map[a:1.5], got: %v", v)
+		return
+	}
+
+	data, err = codec.Marshal(map[string]Item{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if decoded, err := codec.Unmarshal(data); err != nil || decoded == nil {
+		t.Errorf("empty map not decoded, got: %v, %v", decoded, err)
+		return
+	}
+}
+
+func TestMsgpackCodec(t *testing.T) {
+	items := map[string]Item{
+		"string":  {Object: "v1", ExpireMs: kNeverExpireMs},
+		"bool":    {Object: true, ExpireMs: 1},
+		"int":     {Object: int(-1 << 40), ExpireMs: -1},
+		"int8":    {Object: int8(-100), ExpireMs: 1 << 20},
+		"uint":    {Object: uint(1 << 63), ExpireMs: 2},
+		"uint16":  {Object: uint16(300), ExpireMs: 3},
+		"float32": {Object: float32(1.5), ExpireMs: 4},
+		"float64": {Object: float64(-2.25), ExpireMs: 5},
+		"slice":   {Object: []int32{1, -200, 1 << 20}, ExpireMs: 6},
+		"nil":     {Object: []string(nil), ExpireMs: 7},
+		"bytes":   {Object: []uint8{1, 2, 3}, ExpireMs: 8},
+		"map":     {Object: map[string]uint64{"a": 1, "b": 1 << 40}, ExpireMs: 9},
+		"long":    {Object: strings.Repeat("x", 300), ExpireMs: 10},
+	}
+
+	var codec MsgpackCodec
+	data, err := codec.Marshal(items)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	decoded, err := codec.Unmarshal(data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(decoded, items) {
+		t.Errorf("round trip error, expect: %v, got: %v", items, decoded)
+		return
+	}
+
+	if _, err := codec.Unmarshal(data[:len(data)-1]); err != ErrMalformedData {
+		t.Errorf("truncated data, expect: %v, got: %v", ErrMalformedData, err)
+		return
+	}
+
+	type custom struct{ A int }
+	if _, err := codec.Marshal(map[string]Item{"k1": {Object: custom{1}}}); !errors.Is(err, ErrInvalidType) {
+		t.Errorf("custom type, expect: %v, got: %v", ErrInvalidType, err)
+		return
+	}
+}
+
+func benchmarkItems() map[string]Item {
+	items := make(map[string]Item)
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("key:%d", i)
+		switch i % 4 {
+		case 0:
+			items[key] = Item{Object: fmt.Sprintf("value:%d", i), ExpireMs: kNeverExpireMs}
+		case 1:
+			items[key] = Item{Object: int64(i), ExpireMs: int64(1700000000000 + i)}
+		case 2:
+			items[key] = Item{Object: []float64{float64(i), 1.5}, ExpireMs: kNeverExpireMs}
+		case 3:
+			items[key] = Item{Object: map[string]int{"a": i}, ExpireMs: int64(1700000000000 + i)}
+		}
+	}
+	return items
+}
+
+func benchmarkCodec(b *testing.B, codec Codec) {
+	items := benchmarkItems()
+	data, err := codec.Marshal(items)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("Marshal", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			codec.Marshal(items)
+		}
+		b.ReportMetric(float64(len(data)), "bytes")
+	})
+
+	b.Run("Unmarshal", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			codec.Unmarshal(data)
+		}
+	})
+}
+
+func BenchmarkGobCodec(b *testing.B) {
+	benchmarkCodec(b, GobCodec{})
+}
+
+func BenchmarkMsgpackCodec(b *testing.B) {
+	benchmarkCodec(b, MsgpackCodec{})
+}
@@ -0,0 +1,42 @@
+package gcache
+
+import "time"
+
+// Compact rebuilds the maps of the cache sized to the items they hold, to
+// return the memory of deleted and expired items, as Go maps never shrink.
+// It helps after a burst of items, once most of them are gone. It copies all
+// items with the cache locked for writing, so it costs about as much as a
+// Keys call and blocks readers and writers meanwhile. The Namespace views
+// compact the whole cache.
+func (c *Cache) Compact() {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	c.compact()
+}
+
+// compact must be called with c.mtx held for writing.
+func (c *Cache) compact() {
+	persistItems := make(map[string]Item, len(c.persistItems))
+	for key, item := range c.persistItems {
+		persistItems[key] = item
+	}
+	c.persistItems = persistItems
+
+	volatileItems := make(map[string]Item, len(c.volatileItems))
+	for key, item := range c.volatileItems {
+		volatileItems[key] = item
+	}
+	c.volatileItems = volatileItems
+	c.rebuildExpiries()
+
+	if c.timers != nil {
+		timers := make(map[string]*time.Timer, len(c.timers))
+		for key, t := range c.timers {
+			timers[key] = t
+		}
+		c.timers = timers
+	}
+
+	c.lastCompactMs = c.nowMs()
+}
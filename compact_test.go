@@ -0,0 +1,61 @@
+package gcache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCompact(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := &fakeClock{now: time.UnixMilli(10000)}
+	c, err := NewWithClock(ctx, 0, 0, nil, clock, WithCompactInterval(time.Minute))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	for i := 0; i < 1000; i++ {
+		Set(c, BuildKey("k", i), i, time.Second)
+	}
+	Set(c, "p1", 1, NEVER_EXPIRE)
+	Set(c, "v1", 2, time.Hour)
+
+	clock.Advance(time.Second * 2)
+	c.cleanup()
+	if n := len(c.expiries); n != 1 {
+		t.Errorf("invalid expiry index, expect: 1 entry, got: %v", n)
+		return
+	}
+
+	// not due yet
+	c.tick()
+	if c.lastCompactMs != 10000 {
+		t.Error("compacted before the interval passed")
+		return
+	}
+
+	clock.Advance(time.Minute)
+	c.tick()
+	if c.lastCompactMs != clock.Now().UnixMilli() {
+		t.Error("not compacted once the interval passed")
+		return
+	}
+
+	c.Compact()
+	if Len(c) != 2 || !Exists(c, "p1") || !Exists(c, "v1") {
+		t.Errorf("items lost by compaction, got: %v", Keys(c))
+		return
+	}
+
+	// the expiry index still works
+	clock.Advance(time.Hour)
+	c.cleanup()
+	if Exists(c, "v1") || !Exists(c, "p1") {
+		t.Errorf("invalid items after cleanup, got: %v", Keys(c))
+		return
+	}
+}
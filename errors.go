@@ -1,8 +1,27 @@
 package gcache
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
-	ErrNotExists   = errors.New("key not exists")
-	ErrInvalidType = errors.New("invalid type")
+	ErrNotExists         = errors.New("key not exists")
+	ErrInvalidType       = errors.New("invalid type")
+	ErrIndexOutOfRange   = errors.New("index out of range")
+	ErrMalformedData     = errors.New("malformed data")
+	ErrKeyTooLong        = errors.New("key too long")
+	ErrVersionMismatch   = errors.New("version mismatch")
+	ErrInvalidTTL        = errors.New("invalid ttl")
+	ErrPersistBacklog    = errors.New("persist backlog full")
+	ErrUnsupportedFormat = errors.New("unsupported format")
+
+	// ErrExpired tells an expired key not yet cleaned up from a missing one,
+	// errors.Is(ErrExpired, ErrNotExists) holds.
+	ErrExpired = fmt.Errorf("%w: expired", ErrNotExists)
 )
+
+// invalidTypeError wraps ErrInvalidType with the type actually stored.
+func invalidTypeError(obj interface{}) error {
+	return fmt.Errorf("%w: stored as %T", ErrInvalidType, obj)
+}
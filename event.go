@@ -0,0 +1,164 @@
+package gcache
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	kEventBufferSize = 64
+)
+
+type EventType int
+
+const (
+	EventSet     EventType = iota // key written
+	EventDel                      // key deleted
+	EventExpired                  // key removed after expiration
+	EventEvicted                  // key removed by MaxItems or Shed
+)
+
+type Event struct {
+	Key   string
+	Type  EventType
+	Value interface{} // new value for EventSet, removed value otherwise
+}
+
+type subscriber struct {
+	pattern string
+	prefix  string // namespace of the subscriber, stripped from the keys
+	ch      chan Event
+}
+
+// Watch subscribes to the events of the keys matching keyOrPattern, which is
+// either a key or a path.Match pattern such as "session:*". A malformed
+// pattern matches nothing. Call the returned func to unsubscribe, which closes
+// the channel.
+//
+// Delivery is best effort and not durable: events are sent without blocking
+// to a channel buffering kEventBufferSize events, and dropped when it is full,
+// see DroppedEvents. Events are published while the mutation still holds the
+// write lock, so for each subscriber they arrive in the exact order the
+// mutations were applied, and the event of a write is enqueued before any
+// reader can observe a newer value.
+func (c *Cache) Watch(keyOrPattern string) (<-chan Event, func()) {
+	sub := &subscriber{
+		pattern: c.prefix + keyOrPattern,
+		prefix:  c.prefix,
+		ch:      make(chan Event, kEventBufferSize),
+	}
+
+	c.mtx.Lock()
+	if c.subs == nil {
+		c.subs = make(map[*subscriber]struct{})
+	}
+	c.subs[sub] = struct{}{}
+	c.mtx.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			c.mtx.Lock()
+			delete(c.subs, sub)
+			close(sub.ch)
+			c.mtx.Unlock()
+		})
+	}
+
+	return sub.ch, unsubscribe
+}
+
+// DroppedEvents returns the number of events dropped because a subscriber's
+// buffer was full.
+func (c *Cache) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&c.droppedEvents)
+}
+
+// record reports a mutation of key to the dirty keys, the op log and the
+// subscribers. Must be called with c.mtx held for writing, in mutation order.
+func (c *Cache) record(key string, typ EventType, item Item) {
+	if c.dirty != nil {
+		c.dirty[key] = struct{}{}
+	}
+
+	if c.oplog != nil {
+		var err error
+		switch typ {
+		case EventSet:
+			err = c.oplog.LogSet(key, item)
+		case EventDel, EventEvicted:
+			err = c.oplog.LogDel(key)
+		}
+		if err != nil {
+			c.persistErr = err
+			if c.pendingLogErr == nil {
+				c.pendingLogErr = err
+			}
+		}
+	}
+
+	// an item removed after its expiration expired, whoever removed it, so
+	// each expired item is reported once as such
+	if (typ == EventDel || typ == EventEvicted) && !item.neverExpire() && c.expired(item) {
+		typ = EventExpired
+	}
+	if typ == EventExpired && c.opts.onExpired != nil {
+		c.pendingExpired = append(c.pendingExpired, expiredItem{key: key, val: item.Object})
+	}
+
+	c.publish(key, typ, item.Object)
+}
+
+type expiredItem struct {
+	key string
+	val interface{}
+}
+
+// unlock releases c.mtx held for writing, then calls the OnExpired callback
+// for the items expired meanwhile and the OnPersistError callback for the
+// first log append which failed meanwhile, so every mutation delivers the
+// callbacks it queued.
+func (c *Cache) unlock() {
+	pending := c.pendingExpired
+	c.pendingExpired = nil
+	logErr := c.pendingLogErr
+	c.pendingLogErr = nil
+	c.mtx.Unlock()
+
+	for _, e := range pending {
+		c.callOnExpired(e)
+	}
+	if logErr != nil && c.opts.onPersistError != nil {
+		c.opts.onPersistError(logErr)
+	}
+}
+
+func (c *Cache) callOnExpired(e expiredItem) {
+	defer func() {
+		recover()
+	}()
+	c.opts.onExpired(e.key, e.val)
+}
+
+// publish sends the event to all matching subscribers. Must be called with
+// c.mtx held for writing.
+func (c *Cache) publish(key string, typ EventType, value interface{}) {
+	for sub := range c.subs {
+		if !strings.HasPrefix(key, sub.prefix) {
+			continue
+		}
+		if sub.pattern != key {
+			if matched, _ := path.Match(sub.pattern, key); !matched {
+				continue
+			}
+		}
+
+		select {
+		case sub.ch <- Event{Key: key[len(sub.prefix):], Type: typ, Value: value}:
+		default:
+			atomic.AddUint64(&c.droppedEvents, 1)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package gcache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, time.Millisecond*10, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	events, unsubscribe := c.Watch("session:*")
+	defer unsubscribe()
+
+	Set(c, "session:1", "v1", NEVER_EXPIRE)
+	Set(c, "other", "v2", NEVER_EXPIRE)
+	Delete(c, "session:1")
+	Set(c, "session:2", "v3", time.Millisecond*10)
+
+	expects := []Event{
+		{Key: "session:1", Type: EventSet, Value: "v1"},
+		{Key: "session:1", Type: EventDel, Value: "v1"},
+		{Key: "session:2", Type: EventSet, Value: "v3"},
+		{Key: "session:2", Type: EventExpired, Value: "v3"},
+	}
+	for _, expect := range expects {
+		select {
+		case ev := <-events:
+			if ev != expect {
+				t.Errorf("event error, expect: %v, got: %v", expect, ev)
+				return
+			}
+		case <-time.After(time.Second):
+			t.Errorf("event timeout, expect: %v", expect)
+			return
+		}
+	}
+
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Error("channel not closed after unsubscribe")
+		return
+	}
+}
+
+func TestWatchOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", 0, NEVER_EXPIRE)
+
+	events, unsubscribe := c.Watch("k1")
+	defer unsubscribe()
+
+	const n = kEventBufferSize
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Increase(c, "k1", 1)
+		}()
+	}
+	wg.Wait()
+
+	for i := 1; i <= n; i++ {
+		ev := <-events
+		if ev.Value != i {
+			t.Errorf("event out of order, expect: %v, got: %v", i, ev.Value)
+			return
+		}
+	}
+
+	Increase(c, "k1", 1)
+	if dropped := c.DroppedEvents(); dropped != 0 {
+		t.Errorf("invalid number of dropped events, expect: 0, got: %v", dropped)
+		return
+	}
+}
+
+func TestOnExpired(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mtx sync.Mutex
+	expired := make(map[string][]interface{})
+	onExpired := func(key string, val interface{}) {
+		mtx.Lock()
+		expired[key] = append(expired[key], val)
+		mtx.Unlock()
+	}
+
+	clock := &fakeClock{now: time.UnixMilli(10000)}
+	c, err := NewWithClock(ctx, 0, 0, nil, clock, WithOnExpired(onExpired))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "cleaned", "v1", time.Second)
+	Set(c, "deleted", "v2", time.Second)
+	Set(c, "overwritten", "v3", time.Second)
+	Set(c, "kept", "v4", time.Minute)
+	Set(c, "removed", "v5", time.Minute)
+	clock.Advance(time.Second * 2)
+
+	Delete(c, "deleted")
+	Set(c, "overwritten", "v6", NEVER_EXPIRE)
+	Delete(c, "removed")
+	c.cleanup()
+	c.cleanup()
+
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	expects := map[string]interface{}{"cleaned": "v1", "deleted": "v2", "overwritten": "v3"}
+	if len(expired) != len(expects) {
+		t.Errorf("invalid expired items, expect: %v, got: %v", expects, expired)
+		return
+	}
+	for key, val := range expects {
+		if vals := expired[key]; len(vals) != 1 || vals[0] != val {
+			t.Errorf("expect %v to expire once with %v, got: %v", key, val, vals)
+			return
+		}
+	}
+}
+
+func TestOnExpiredWithoutCleanup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mtx sync.Mutex
+	var expired []string
+	onExpired := func(key string, val interface{}) {
+		mtx.Lock()
+		expired = append(expired, key)
+		mtx.Unlock()
+	}
+
+	clock := &fakeClock{now: time.UnixMilli(10000)}
+	c, err := NewWithClock(ctx, 0, 0, nil, clock, WithOnExpired(onExpired))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", "v1", time.Second)
+	Set(c, "k2", "v2", time.Second)
+	clock.Advance(time.Second * 2)
+
+	if err := SetAny(c, "k1", "v3", NEVER_EXPIRE); err != nil {
+		t.Error("set error:", err)
+		return
+	}
+	if _, _, err := GetSet(c, "k2", "v4", NEVER_EXPIRE); err != nil {
+		t.Error("get set error:", err)
+		return
+	}
+
+	mtx.Lock()
+	got := len(expired)
+	mtx.Unlock()
+	if got != 2 {
+		t.Errorf("expect 2 expired items before close, got: %v", expired)
+		return
+	}
+
+	c.mtx.RLock()
+	pending := len(c.pendingExpired)
+	c.mtx.RUnlock()
+	if pending != 0 {
+		t.Errorf("expect no pending expired items, got: %v", pending)
+		return
+	}
+}
@@ -0,0 +1,163 @@
+package gcache
+
+import "runtime"
+
+// EvictionPolicy chooses the items to evict once the cache holds more than
+// MaxItems, see WithMaxItems.
+type EvictionPolicy int
+
+const (
+	// VolatileTTL evicts the volatile item expiring soonest, and persistent
+	// items only as a last resort, like the volatile-ttl policy of Redis.
+	VolatileTTL EvictionPolicy = iota
+)
+
+// evict removes items until at most MaxItems are left, sparing the key just
+// written. Must be called with c.mtx held.
+func (c *Cache) evict(spare string) {
+	if c.opts.maxItems <= 0 {
+		return
+	}
+
+	for len(c.persistItems)+len(c.volatileItems) > c.opts.maxItems {
+		key, item, found := c.evictee(spare)
+		if !found {
+			return
+		}
+
+		delete(c.persistItems, key)
+		delete(c.volatileItems, key)
+		c.stopTimer(key)
+		c.changes++
+		c.record(key, EventEvicted, item)
+	}
+}
+
+// evictee picks the next item to evict by the eviction policy, popping the
+// volatile ones off the expiry index. Must be called with c.mtx held.
+func (c *Cache) evictee(spare string) (key string, item Item, found bool) {
+	// every item expires before kNeverExpireMs, so this pops the next one
+	key, item, found = c.popExpired(kNeverExpireMs)
+	if found && key == spare {
+		spared := item
+		key, item, found = c.popExpired(kNeverExpireMs)
+		c.indexExpiry(spare, spared.ExpireMs)
+	}
+	if found {
+		return
+	}
+
+	for k, it := range c.persistItems {
+		if k != spare {
+			return k, it, true
+		}
+	}
+	return
+}
+
+// Shed evicts the given fraction of the volatile items, least recently used
+// first, e.g. when the process runs short of memory, see WithMemoryPressure.
+// Without WithMemoryPressure the cache doesn't track recency, and the items
+// expiring soonest go first instead. Persistent items are kept. Returns the
+// number of items evicted, which fire EventEvicted.
+func (c *Cache) Shed(fraction float64) int {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	n := int(fraction * float64(len(c.volatileItems)))
+	if n == 0 && fraction > 0 && len(c.volatileItems) > 0 {
+		n = 1
+	}
+
+	evicted := 0
+	for evicted < n {
+		key, item, found := c.popLeastRecent()
+		if !found {
+			// every item expires before kNeverExpireMs, so this pops the
+			// next one
+			key, item, found = c.popExpired(kNeverExpireMs)
+		}
+		if !found {
+			break
+		}
+		delete(c.volatileItems, key)
+		c.stopTimer(key)
+		c.changes++
+		c.record(key, EventEvicted, item)
+		evicted++
+	}
+	c.notifyEmpty()
+
+	return evicted
+}
+
+// touch marks the volatile item of key as the most recently used, if the
+// cache tracks recency. Reads only move keys already tracked, so a read racing
+// with a delete doesn't bring the key back. Writes must be called with c.mtx
+// held.
+func (c *Cache) touch(key string, write bool) {
+	if c.recency == nil {
+		return
+	}
+
+	c.recencyMtx.Lock()
+	defer c.recencyMtx.Unlock()
+
+	if e, exists := c.recencyKeys[key]; exists {
+		c.recency.MoveToFront(e)
+		return
+	}
+	if !write {
+		return
+	}
+	c.recencyKeys[key] = c.recency.PushFront(key)
+
+	// deleted keys are dropped lazily, prune them once they outnumber the
+	// live ones
+	if c.recency.Len() > 2*len(c.volatileItems)+1024 {
+		for e := c.recency.Front(); e != nil; {
+			next := e.Next()
+			if k := e.Value.(string); !c.isVolatile(k) {
+				c.recency.Remove(e)
+				delete(c.recencyKeys, k)
+			}
+			e = next
+		}
+	}
+}
+
+func (c *Cache) isVolatile(key string) bool {
+	_, exists := c.volatileItems[key]
+	return exists
+}
+
+// popLeastRecent pops the least recently used volatile item off the recency
+// list, skipping the keys deleted meanwhile. Must be called with c.mtx held.
+func (c *Cache) popLeastRecent() (string, Item, bool) {
+	if c.recency == nil {
+		return "", Item{}, false
+	}
+
+	c.recencyMtx.Lock()
+	defer c.recencyMtx.Unlock()
+
+	for e := c.recency.Back(); e != nil; e = c.recency.Back() {
+		key := c.recency.Remove(e).(string)
+		delete(c.recencyKeys, key)
+		if item, exists := c.volatileItems[key]; exists {
+			return key, item, true
+		}
+	}
+	return "", Item{}, false
+}
+
+// HeapAbove returns a memory pressure check for WithMemoryPressure, which
+// reports whether the heap in use exceeds limit bytes. It reads the memory
+// stats of the runtime, which briefly stops the world.
+func HeapAbove(limit uint64) func() bool {
+	return func() bool {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		return ms.HeapAlloc > limit
+	}
+}
@@ -0,0 +1,132 @@
+package gcache
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestVolatileTTL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil, WithMaxItems(3))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	events, unsubscribe := c.Watch("*")
+	defer unsubscribe()
+
+	Set(c, "p1", 1, NEVER_EXPIRE)
+	Set(c, "v1", 2, time.Hour)
+	Set(c, "v2", 3, time.Minute)
+	Set(c, "v3", 4, time.Minute*10)
+	if Exists(c, "v2") || Len(c) != 3 {
+		t.Error("soonest to expire item not evicted")
+		return
+	}
+
+	// the key just written is spared, then persistent items go last
+	Set(c, "p2", 5, NEVER_EXPIRE)
+	Set(c, "p3", 6, NEVER_EXPIRE)
+	Set(c, "p4", 7, NEVER_EXPIRE)
+	if !Exists(c, "p4") || Exists(c, "v1") || Exists(c, "v3") || Len(c) != 3 {
+		t.Errorf("invalid eviction, left: %v", Keys(c))
+		return
+	}
+
+	// a spared volatile item stays indexed for the next eviction
+	Set(c, "v4", 8, time.Second)
+	Set(c, "v5", 9, time.Hour)
+	if Exists(c, "v4") || !Exists(c, "v5") || Len(c) != 3 {
+		t.Errorf("invalid eviction, left: %v", Keys(c))
+		return
+	}
+
+	evicted := 0
+	for len(events) > 0 {
+		if ev := <-events; ev.Type == EventEvicted {
+			evicted++
+		}
+	}
+	if evicted != 6 {
+		t.Errorf("invalid number of eviction events, expect: 6, got: %v", evicted)
+		return
+	}
+}
+
+func TestMemoryPressure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var pressure int32
+	check := func() bool {
+		return atomic.LoadInt32(&pressure) != 0
+	}
+
+	c, err := New(ctx, time.Millisecond*10, 0, nil, WithMemoryPressure(check, 0.5))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "p1", 0, NEVER_EXPIRE)
+	for i := 0; i < 10; i++ {
+		Set(c, BuildKey("k", i), i, time.Minute+time.Duration(i)*time.Second)
+	}
+
+	time.Sleep(time.Millisecond * 30)
+	if n := Len(c); n != 11 {
+		t.Errorf("items shed without pressure, left: %v", n)
+		return
+	}
+
+	// reading k0 makes k1 the least recently used
+	Get[int](c, BuildKey("k", 0))
+
+	atomic.StoreInt32(&pressure, 1)
+	deadline := time.Now().Add(time.Second)
+	for Len(c) > 6 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	atomic.StoreInt32(&pressure, 0)
+	time.Sleep(time.Millisecond * 30)
+
+	// the least recently used go first, persistent items are kept
+	if !Exists(c, "p1") || !Exists(c, BuildKey("k", 0)) || Exists(c, BuildKey("k", 1)) || !Exists(c, BuildKey("k", 9)) {
+		t.Errorf("invalid items shed, left: %v", Keys(c))
+		return
+	}
+
+	if n := c.Shed(1); n == 0 || !Exists(c, "p1") || Len(c) != 1 {
+		t.Errorf("invalid shed, left: %v", Keys(c))
+		return
+	}
+}
+
+func TestShedWithoutRecency(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", 1, time.Hour)
+	Set(c, "k2", 2, time.Minute)
+	Get[int](c, "k2")
+
+	// recency isn't tracked, so the soonest to expire goes first
+	if n := c.Shed(0.5); n != 1 || Exists(c, "k2") || !Exists(c, "k1") {
+		t.Errorf("invalid shed, left: %v", Keys(c))
+		return
+	}
+}
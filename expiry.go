@@ -0,0 +1,58 @@
+package gcache
+
+import "container/heap"
+
+// The expiry index is a min-heap of the volatile items by expiration, so the
+// cleanup only visits the items actually due. Entries are never removed in
+// place: an entry whose item was deleted or rewritten with another expiration
+// is stale, and skipped once popped. All helpers must be called with c.mtx
+// held.
+
+type expiryEntry struct {
+	expireMs int64
+	key      string
+}
+
+type expiryHeap []expiryEntry
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].expireMs < h[j].expireMs }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(expiryEntry)) }
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// indexExpiry adds a volatile item to the expiry index.
+func (c *Cache) indexExpiry(key string, expireMs int64) {
+	heap.Push(&c.expiries, expiryEntry{expireMs: expireMs, key: key})
+
+	// rebuild once stale entries outnumber the live ones
+	if len(c.expiries) > 2*len(c.volatileItems)+1024 {
+		c.rebuildExpiries()
+	}
+}
+
+func (c *Cache) rebuildExpiries() {
+	c.expiries = make(expiryHeap, 0, len(c.volatileItems))
+	for key, item := range c.volatileItems {
+		c.expiries = append(c.expiries, expiryEntry{expireMs: item.ExpireMs, key: key})
+	}
+	heap.Init(&c.expiries)
+}
+
+// popExpired pops the next item expired at nowMs off the index, skipping the
+// stale entries.
+func (c *Cache) popExpired(nowMs int64) (string, Item, bool) {
+	for len(c.expiries) > 0 && c.expiries[0].expireMs < nowMs {
+		e := heap.Pop(&c.expiries).(expiryEntry)
+		if item, exists := c.volatileItems[e.key]; exists && item.ExpireMs == e.expireMs {
+			return e.key, item, true
+		}
+	}
+	return "", Item{}, false
+}
@@ -0,0 +1,147 @@
+package gcache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExpiryIndex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := &fakeClock{now: time.Now()}
+	c, err := NewWithClock(ctx, 0, 0, nil, clock)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", 1, time.Second)
+	Set(c, "k2", 2, time.Second)
+	Set(c, "k3", 3, time.Second)
+	Set(c, "k2", 2, time.Hour)    // stale entry, extended
+	Set(c, "k3", 3, NEVER_EXPIRE) // stale entry, made persistent
+	Set(c, "k4", 4, time.Second)
+	Delete(c, "k4")                // stale entry, deleted
+	Set(c, "k4", 4, time.Second*3) // stale entry, re-added later
+
+	clock.Advance(time.Second * 2)
+	c.cleanup()
+	if keys := Keys(c); len(keys) != 3 || Exists(c, "k1") {
+		t.Errorf("invalid cleanup, left: %v", keys)
+		return
+	}
+
+	// rewrites don't grow the index without bound
+	for i := 0; i < 10000; i++ {
+		Set(c, "k5", i, time.Minute+time.Duration(i)*time.Millisecond)
+	}
+	if n := len(c.expiries); n > 2*len(c.volatileItems)+1024 {
+		t.Errorf("expiry index not rebuilt, got %v entries", n)
+		return
+	}
+}
+
+func TestCleanupBatchSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := &fakeClock{now: time.Now()}
+	c, err := NewWithClock(ctx, 0, 0, nil, clock, WithCleanupBatchSize(10))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	for _, n := range []int{95, 100} {
+		for i := 0; i < n; i++ {
+			Set(c, BuildKey("k", i), i, time.Second)
+		}
+		Set(c, "live", 0, time.Hour)
+
+		clock.Advance(time.Second * 2)
+		c.cleanup()
+		if keys := Keys(c); Len(c) != 1 || len(keys) != 1 {
+			t.Errorf("expired items left after cleanup of %v: %v", n, Len(c))
+			return
+		}
+	}
+}
+
+// cleanupSweep is the linear sweep the expiry index replaced, kept to compare.
+func (c *Cache) cleanupSweep() {
+	nowMs := c.nowMs()
+	c.mtx.Lock()
+	for key, item := range c.volatileItems {
+		if nowMs > item.ExpireMs {
+			delete(c.volatileItems, key)
+			c.stopTimer(key)
+			c.changes++
+			c.record(key, EventExpired, item)
+		}
+	}
+	c.notifyEmpty()
+	c.mtx.Unlock()
+}
+
+func BenchmarkCleanup(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		b.Fatal("create cache error:", err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 500000; i++ {
+		Set(c, BuildKey("k", i), i, time.Hour)
+	}
+
+	b.Run("Index", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			c.cleanup()
+		}
+	})
+	b.Run("Sweep", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			c.cleanupSweep()
+		}
+	})
+}
+
+func TestExpiryPrecision(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := &fakeClock{now: time.UnixMilli(10500)}
+	c, err := NewWithClock(ctx, 0, 0, nil, clock, WithExpiryPrecision(time.Second))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", 1, time.Millisecond*100)
+	Set(c, "k2", 2, time.Millisecond*400)
+	SetAt(c, "k3", 3, time.UnixMilli(10001))
+	Set(c, "k4", 4, NEVER_EXPIRE)
+
+	for _, key := range []string{"k1", "k2"} {
+		if item := c.volatileItems[key]; item.ExpireMs != 11000 {
+			t.Errorf("expiration of %v not rounded up, got: %v", key, item.ExpireMs)
+			return
+		}
+	}
+	if item := c.volatileItems["k3"]; item.ExpireMs != 11000 {
+		t.Errorf("expiration of k3 not rounded up, got: %v", item.ExpireMs)
+		return
+	}
+	if p, _ := IsPersistent(c, "k4"); !p {
+		t.Error("persistent item made volatile")
+		return
+	}
+}
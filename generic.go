@@ -1,6 +1,14 @@
 package gcache
 
-import "time"
+import (
+	"container/heap"
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"path"
+	"sort"
+	"time"
+)
 
 type NumType interface {
 	float32 | float64 |
@@ -33,6 +41,8 @@ type ValType interface {
 }
 
 func Exists(c *Cache, key string) bool {
+	key = c.key(key)
+
 	c.mtx.RLock()
 	defer c.mtx.RUnlock()
 
@@ -41,361 +51,1428 @@ func Exists(c *Cache, key string) bool {
 		return true
 	}
 
-	item, exists := c.volatileItems[key]
-	return exists && !item.expired()
+	item, exists := c.volatileItems[key]
+	return exists && !c.expired(item)
+}
+
+// ExistsMulti tells for each of keys whether it exists, like Exists, locking
+// the cache once.
+func ExistsMulti(c *Cache, keys []string) map[string]bool {
+	found := make(map[string]bool, len(keys))
+
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	nowMs := c.nowMs()
+	for _, key := range keys {
+		k := c.key(key)
+		if _, exists := c.persistItems[k]; exists {
+			found[key] = true
+			continue
+		}
+		item, exists := c.volatileItems[k]
+		found[key] = exists && !item.expiredAt(nowMs)
+	}
+
+	return found
+}
+
+// WARNING: If value is in SliceType or MapType, the operation on the returned value is not thread-safe.
+// With WithLoader, a miss loads the value, the signature stays the same.
+func Get[T ValType](c *Cache, key string) (retV T, retErr error) {
+	extKey, key := c.extKey(key), c.key(key)
+
+	var item Item
+	var exists, volatile bool
+
+	c.mtx.RLock()
+	item, exists = c.persistItems[key]
+	if !exists {
+		item, exists = c.volatileItems[key]
+		exists = exists && !c.expired(item)
+		volatile = exists
+	}
+	c.mtx.RUnlock()
+
+	if volatile {
+		c.touch(key, false)
+	}
+	c.countLookup(exists)
+	if !exists {
+		if c.opts.loader == nil {
+			retErr = ErrNotExists
+			return
+		}
+		if item.Object, retErr = c.load(key, extKey); retErr != nil {
+			return
+		}
+	}
+
+	v, ok := item.Object.(T)
+	if !ok {
+		retErr = invalidTypeError(item.Object)
+		return
+	}
+
+	return v, nil
+}
+
+// Get a value of any type, e.g. one stored by SetAny.
+func GetAny[T any](c *Cache, key string) (retV T, retErr error) {
+	extKey, key := c.extKey(key), c.key(key)
+
+	var item Item
+	var exists, volatile bool
+
+	c.mtx.RLock()
+	item, exists = c.persistItems[key]
+	if !exists {
+		item, exists = c.volatileItems[key]
+		exists = exists && !c.expired(item)
+		volatile = exists
+	}
+	c.mtx.RUnlock()
+
+	if volatile {
+		c.touch(key, false)
+	}
+	c.countLookup(exists)
+	if !exists {
+		if c.opts.loader == nil {
+			retErr = ErrNotExists
+			return
+		}
+		if item.Object, retErr = c.load(key, extKey); retErr != nil {
+			return
+		}
+	}
+
+	v, ok := item.Object.(T)
+	if !ok {
+		retErr = invalidTypeError(item.Object)
+		return
+	}
+
+	return v, nil
+}
+
+// Get a copy of the value, slice and map values are deep copied so the result
+// is safe to use concurrently. Scalars are returned without extra allocation.
+func GetCopy[T ValType](c *Cache, key string) (retV T, retErr error) {
+	key = c.key(key)
+
+	var item Item
+	var exists bool
+
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	item, exists = c.persistItems[key]
+	if !exists {
+		item, exists = c.volatileItems[key]
+		if !exists || c.expired(item) {
+			retErr = ErrNotExists
+			return
+		}
+	}
+
+	if _, ok := item.Object.(T); !ok {
+		retErr = invalidTypeError(item.Object)
+		return
+	}
+
+	return cloneObject(item.Object).(T), nil
+}
+
+// GetAllOfType returns all unexpired items whose value is a T, skipping the
+// others. Slice and map values are deep copied like GetCopy.
+func GetAllOfType[T ValType](c *Cache) map[string]T {
+	all := make(map[string]T)
+
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	for key, item := range c.persistItems {
+		if k, own := c.ownKey(key); own {
+			if _, ok := item.Object.(T); ok {
+				all[k] = cloneObject(item.Object).(T)
+			}
+		}
+	}
+	nowMs := c.nowMs()
+	for key, item := range c.volatileItems {
+		if k, own := c.ownKey(key); own && !item.expiredAt(nowMs) {
+			if _, ok := item.Object.(T); ok {
+				all[k] = cloneObject(item.Object).(T)
+			}
+		}
+	}
+
+	return all
+}
+
+// Get the value, blocking until the key is set if it doesn't exist yet.
+// Returns ctx.Err() if ctx is done first.
+func GetWait[T ValType](ctx context.Context, c *Cache, key string) (retV T, retErr error) {
+	key = c.key(key)
+
+	for {
+		c.mtx.Lock()
+		item, exists := c.persistItems[key]
+		if !exists {
+			item, exists = c.volatileItems[key]
+			exists = exists && !c.expired(item)
+		}
+		if exists {
+			c.unlock()
+
+			v, ok := item.Object.(T)
+			if !ok {
+				retErr = invalidTypeError(item.Object)
+				return
+			}
+			return v, nil
+		}
+		w := c.wait(key)
+		c.unlock()
+
+		select {
+		case <-w.ch:
+		case <-ctx.Done():
+			c.unwait(key, w)
+			retErr = ctx.Err()
+			return
+		}
+	}
+}
+
+// TryGet returns the value and true, or the zero value and false on a miss or
+// a type mismatch, like the comma ok idiom of maps.
+func TryGet[T ValType](c *Cache, key string) (val T, ok bool) {
+	val, err := Get[T](c, key)
+	return val, err == nil
+}
+
+// Get the value, or def on a miss or a type mismatch.
+func GetOrDefault[T ValType](c *Cache, key string, def T) T {
+	if v, err := Get[T](c, key); err == nil {
+		return v
+	}
+	return def
+}
+
+// IsPersistent reports whether the item never expires, and so survives a
+// restart if the cache has a persister.
+func IsPersistent(c *Cache, key string) (bool, error) {
+	key = c.key(key)
+
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	if _, exists := c.persistItems[key]; exists {
+		return true, nil
+	}
+
+	item, exists := c.volatileItems[key]
+	if !exists || c.expired(item) {
+		return false, ErrNotExists
+	}
+	return false, nil
+}
+
+// HasExpiry reports whether the item expires, the opposite of IsPersistent,
+// to branch before using the TTL of GetTTL as a duration.
+func HasExpiry(c *Cache, key string) (bool, error) {
+	persistent, err := IsPersistent(c, key)
+	return !persistent && err == nil, err
+}
+
+// GetTTL returns the remaining TTL, or NEVER_EXPIRE for a persistent item,
+// see IsNeverExpire. It fails with ErrExpired for an expired item not yet
+// cleaned up, and with ErrNotExists for a missing one.
+func GetTTL(c *Cache, key string) (time.Duration, error) {
+	key = c.key(key)
+
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	if _, exists := c.persistItems[key]; exists {
+		return NEVER_EXPIRE, nil
+	}
+
+	item, exists := c.volatileItems[key]
+	if !exists {
+		return 0, ErrNotExists
+	}
+
+	nowMs := c.nowMs()
+	if item.expiredAt(nowMs) {
+		return 0, ErrExpired
+	}
+	return time.Duration(item.ExpireMs-nowMs) * time.Millisecond, nil
+}
+
+// GetWithTTL returns the value with its TTL, failing like GetTTL. The type is
+// only checked for unexpired items.
+func GetWithTTL[T ValType](c *Cache, key string) (T, time.Duration, error) {
+	key = c.key(key)
+
+	var t T
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	ttl := NEVER_EXPIRE
+	item, exists := c.persistItems[key]
+	if !exists {
+		item, exists = c.volatileItems[key]
+		if !exists {
+			return t, 0, ErrNotExists
+		}
+
+		nowMs := c.nowMs()
+		if item.expiredAt(nowMs) {
+			return t, 0, ErrExpired
+		}
+		ttl = time.Duration(item.ExpireMs-nowMs) * time.Millisecond
+	}
+
+	v, ok := item.Object.(T)
+	if !ok {
+		return t, 0, invalidTypeError(item.Object)
+	}
+	return v, ttl, nil
+}
+
+// ExpireNX sets the TTL of the key only if it has none, i.e. it is
+// persistent, like Redis EXPIRE NX. Returns whether the TTL was set, or
+// ErrInvalidTTL, or ErrNotExists for a missing key.
+func ExpireNX(c *Cache, key string, ttl time.Duration) (bool, error) {
+	return expireIf(c, key, ttl, func(oldMs, newMs int64) bool {
+		return oldMs == kNeverExpireMs
+	})
+}
+
+// ExpireGT sets the TTL of the key only if it extends the current one, like
+// Redis EXPIRE GT: persistent keys are never shortened, and NEVER_EXPIRE
+// makes volatile keys persistent. Returns like ExpireNX.
+func ExpireGT(c *Cache, key string, ttl time.Duration) (bool, error) {
+	return expireIf(c, key, ttl, func(oldMs, newMs int64) bool {
+		return newMs > oldMs
+	})
+}
+
+// ExpireLT sets the TTL of the key only if it shortens the current one, like
+// Redis EXPIRE LT, e.g. to cap how long a refreshed key lives: persistent
+// keys always get the TTL. Returns like ExpireNX.
+func ExpireLT(c *Cache, key string, ttl time.Duration) (bool, error) {
+	return expireIf(c, key, ttl, func(oldMs, newMs int64) bool {
+		return newMs < oldMs
+	})
+}
+
+// expireIf rewrites the key with ttl if cond accepts the old and the new
+// expiration.
+func expireIf(c *Cache, key string, ttl time.Duration, cond func(oldMs, newMs int64) bool) (bool, error) {
+	key = c.key(key)
+
+	if err := checkTTL(ttl); err != nil {
+		return false, err
+	}
+
+	c.mtx.Lock()
+	defer c.unlock()
+
+	item, exists := c.persistItems[key]
+	if !exists {
+		item, exists = c.volatileItems[key]
+		if !exists || c.expired(item) {
+			return false, ErrNotExists
+		}
+	}
+
+	expireMs := c.expireMs(ttl)
+	if !cond(item.ExpireMs, expireMs) {
+		return false, nil
+	}
+
+	c.store(key, item.Object, expireMs)
+
+	return true, nil
+}
+
+// CASToken identifies the state of an item at the time it was read, see GetCAS
+// and SetCAS.
+type CASToken struct {
+	version uint64
+}
+
+// Get the value along with a token for a later SetCAS.
+func GetCAS[T ValType](c *Cache, key string) (retV T, token CASToken, retErr error) {
+	key = c.key(key)
+
+	var item Item
+	var exists bool
+
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	item, exists = c.persistItems[key]
+	if !exists {
+		item, exists = c.volatileItems[key]
+		if !exists || c.expired(item) {
+			retErr = ErrNotExists
+			return
+		}
+	}
+
+	v, ok := item.Object.(T)
+	if !ok {
+		retErr = invalidTypeError(item.Object)
+		return
+	}
+
+	return v, CASToken{version: item.version}, nil
+}
+
+// Set the value only if the item hasn't been written since token was issued by
+// GetCAS. Returns false if the token is stale, and fails like SetChecked if
+// the value is rejected. The Writer isn't called.
+func SetCAS[T ValType](c *Cache, key string, val T, token CASToken, ttl time.Duration) (bool, error) {
+	key = c.key(key)
+
+	var item Item
+	var exists bool
+
+	if err := checkTTL(ttl); err != nil {
+		return false, err
+	}
+	if err := c.validate(key, val); err != nil {
+		return false, err
+	}
+
+	c.mtx.Lock()
+	defer c.unlock()
+
+	item, exists = c.persistItems[key]
+	if !exists {
+		item, exists = c.volatileItems[key]
+		if !exists || c.expired(item) {
+			return false, ErrNotExists
+		}
+	}
+
+	if item.version != token.version {
+		return false, nil
+	}
+
+	c.set(key, val, ttl)
+
+	return true, nil
+}
+
+// Replace the value by new only if eq accepts the current value, e.g. a deep
+// comparison of slices or maps. eq runs with the cache locked and must not
+// call back into it, or mutate the current value. Returns whether the value
+// was swapped, ErrNotExists for a missing key and ErrInvalidType if the
+// current value isn't a T. The Writer isn't called.
+func CompareAndSwapFunc[T ValType](c *Cache, key string, eq func(current T) bool, new T, ttl time.Duration) (bool, error) {
+	key = c.key(key)
+
+	var obj interface{} = new
+	if err := checkTTL(ttl); err != nil {
+		return false, err
+	}
+	if err := c.validate(key, obj); err != nil {
+		return false, err
+	}
+
+	c.mtx.Lock()
+	defer c.unlock()
+
+	item, exists := c.persistItems[key]
+	if !exists {
+		item, exists = c.volatileItems[key]
+		if !exists || c.expired(item) {
+			return false, ErrNotExists
+		}
+	}
+
+	current, ok := item.Object.(T)
+	if !ok {
+		return false, invalidTypeError(item.Object)
+	}
+	if !eq(current) {
+		return false, nil
+	}
+
+	c.set(key, obj, ttl)
+
+	return true, nil
+}
+
+// Get the value along with its version, which changes whenever the item is
+// written, e.g. to serve as an ETag. Versions increase monotonically and,
+// being seeded from the startup time, don't repeat across restarts.
+func GetWithVersion[T ValType](c *Cache, key string) (T, uint64, error) {
+	key = c.key(key)
+
+	var t T
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	item, exists := c.persistItems[key]
+	if !exists {
+		item, exists = c.volatileItems[key]
+		if !exists || c.expired(item) {
+			return t, 0, ErrNotExists
+		}
+	}
+
+	v, ok := item.Object.(T)
+	if !ok {
+		return t, 0, invalidTypeError(item.Object)
+	}
+	return v, item.version, nil
+}
+
+// Set the value only if its current version is expected, 0 if the key must not
+// exist, and return the new version. Fails with ErrVersionMismatch otherwise.
+// The Writer isn't called.
+func SetIfVersion[T ValType](c *Cache, key string, val T, expected uint64, ttl time.Duration) (uint64, error) {
+	key = c.key(key)
+
+	var obj interface{} = val
+	if err := checkTTL(ttl); err != nil {
+		return 0, err
+	}
+	if err := c.validate(key, obj); err != nil {
+		return 0, err
+	}
+
+	c.mtx.Lock()
+	defer c.unlock()
+
+	item, exists := c.persistItems[key]
+	if !exists {
+		item, exists = c.volatileItems[key]
+		exists = exists && !c.expired(item)
+	}
+
+	var version uint64
+	if exists {
+		version = item.version
+	}
+	if version != expected {
+		return 0, ErrVersionMismatch
+	}
+
+	c.set(key, obj, ttl)
+
+	return c.seq, nil
+}
+
+// Note: thread-safe but expensive
+func GetSliceCopy[T ScalarType](c *Cache, key string) (retV []T, retErr error) {
+	key = c.key(key)
+
+	var item Item
+	var exists bool
+
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	item, exists = c.persistItems[key]
+	if !exists {
+		item, exists = c.volatileItems[key]
+		if !exists || c.expired(item) {
+			retErr = ErrNotExists
+			return
+		}
+	}
+
+	vv, ok := item.Object.([]T)
+	if !ok {
+		retErr = invalidTypeError(item.Object)
+		return
+	}
+
+	retV = make([]T, len(vv))
+	copy(retV, vv)
+
+	return
+}
+
+// Copy the slice into dst like GetSliceCopy, growing dst if needed, so
+// callers can reuse their buffers. dst is returned truncated on error, to be
+// reused all the same.
+func GetSliceInto[T ScalarType](c *Cache, key string, dst []T) ([]T, error) {
+	key = c.key(key)
+
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	item, exists := c.persistItems[key]
+	if !exists {
+		item, exists = c.volatileItems[key]
+		if !exists || c.expired(item) {
+			return dst[:0], ErrNotExists
+		}
+	}
+
+	vv, ok := item.Object.([]T)
+	if !ok {
+		return dst[:0], invalidTypeError(item.Object)
+	}
+
+	return append(dst[:0], vv...), nil
+}
+
+// Read a single element of a slice cache without copying the whole slice
+func SliceIndex[T ScalarType](c *Cache, key string, i int) (retV T, retErr error) {
+	key = c.key(key)
+
+	var item Item
+	var exists bool
+
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	item, exists = c.persistItems[key]
+	if !exists {
+		item, exists = c.volatileItems[key]
+		if !exists || c.expired(item) {
+			retErr = ErrNotExists
+			return
+		}
+	}
+
+	vv, ok := item.Object.([]T)
+	if !ok {
+		retErr = invalidTypeError(item.Object)
+		return
+	}
+
+	if i < 0 || i >= len(vv) {
+		retErr = ErrIndexOutOfRange
+		return
+	}
+
+	return vv[i], nil
+}
+
+// Note: Thread-safe but expensive
+func GetMapCopy[T ScalarType](c *Cache, key string) (retV map[string]T, retErr error) {
+	key = c.key(key)
+
+	var item Item
+	var exists bool
+
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	item, exists = c.persistItems[key]
+	if !exists {
+		item, exists = c.volatileItems[key]
+		if !exists || c.expired(item) {
+			retErr = ErrNotExists
+			return
+		}
+	}
+
+	vv, ok := item.Object.(map[string]T)
+	if !ok {
+		retErr = invalidTypeError(item.Object)
+		return
+	}
+
+	retV = make(map[string]T)
+	for k, v := range vv {
+		retV[k] = v
+	}
+
+	return
+}
+
+// Set the value. Writes with a negative ttl other than NEVER_EXPIRE, or
+// rejected by MaxKeyLen, the Validator or the Writer are dropped, use
+// SetChecked to get the error.
+func Set[T ValType](c *Cache, key string, val T, ttl time.Duration) {
+	SetChecked(c, key, val, ttl)
+}
+
+// Set the value like Set, returning ErrInvalidTTL or the error if MaxKeyLen,
+// the Validator or the Writer rejects the write.
+func SetChecked[T ValType](c *Cache, key string, val T, ttl time.Duration) error {
+	extKey, key := c.extKey(key), c.key(key)
+
+	var obj interface{} = val
+	if err := checkTTL(ttl); err != nil {
+		return err
+	}
+	if err := c.validate(key, obj); err != nil {
+		return err
+	}
+
+	unlockWrites := c.lockWrites(key)
+	if err := c.write(extKey, obj, ttl); err != nil {
+		unlockWrites()
+		return err
+	}
+
+	c.mtx.Lock()
+	c.set(key, obj, ttl)
+	unlockWrites()
+	c.unlock()
+
+	return nil
+}
+
+// Set the value only if the key exists and isn't expired, like Redis SET XX,
+// e.g. to update a value without creating it by accident. Returns whether it
+// was written; rejected writes are dropped like by Set. The Writer isn't
+// called, see SetWith.
+func SetXX[T ValType](c *Cache, key string, val T, ttl time.Duration) bool {
+	ok, _ := SetWith(c, key, val, TTL(ttl), IfExists())
+	return ok
+}
+
+// Set the value only if the key doesn't exist or holds a T already, to catch
+// type drift at write time. Fails with ErrInvalidType otherwise, or like
+// SetChecked; the Writer isn't called.
+func SetStrict[T ValType](c *Cache, key string, val T, ttl time.Duration) error {
+	key = c.key(key)
+
+	var obj interface{} = val
+	if err := checkTTL(ttl); err != nil {
+		return err
+	}
+	if err := c.validate(key, obj); err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	defer c.unlock()
+
+	item, exists := c.persistItems[key]
+	if !exists {
+		item, exists = c.volatileItems[key]
+		exists = exists && !c.expired(item)
+	}
+	if exists {
+		if _, ok := item.Object.(T); !ok {
+			return invalidTypeError(item.Object)
+		}
+	}
+
+	c.set(key, obj, ttl)
+
+	return nil
+}
+
+// GetSet stores the value and returns the previous one in one step, like
+// GETSET of Redis. Nothing is stored if the previous value isn't a T. The
+// Writer isn't called.
+func GetSet[T ValType](c *Cache, key string, val T, ttl time.Duration) (old T, existed bool, err error) {
+	key = c.key(key)
+
+	var obj interface{} = val
+	if err = checkTTL(ttl); err != nil {
+		return
+	}
+	if err = c.validate(key, obj); err != nil {
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.unlock()
+
+	item, exists := c.persistItems[key]
+	if !exists {
+		item, exists = c.volatileItems[key]
+		exists = exists && !c.expired(item)
+	}
+	if exists {
+		v, ok := item.Object.(T)
+		if !ok {
+			err = invalidTypeError(item.Object)
+			return
+		}
+		old, existed = v, true
+	}
+
+	c.set(key, obj, ttl)
+
+	return
+}
+
+// Set the value with the DefaultTTL, like Set.
+func SetDefault[T ValType](c *Cache, key string, val T) {
+	Set(c, key, val, c.opts.defaultTTL)
+}
+
+// Entry is a value with its TTL, see SetManyTTL.
+type Entry[T ValType] struct {
+	Val T
+	TTL time.Duration // 0 for the DefaultTTL
+}
+
+// Set the values of entries, each with its own TTL, atomically: readers see
+// all of them or none. Fails like SetChecked if an entry is rejected, and
+// then stores nothing, though the Writer may have been handed the entries
+// before the one it rejected.
+func SetManyTTL[T ValType](c *Cache, entries map[string]Entry[T]) error {
+	objs := make(map[string]interface{}, len(entries))
+	ttls := make(map[string]time.Duration, len(entries))
+	for key, e := range entries {
+		var obj interface{} = e.Val
+		ttl := c.resolveTTL(e.TTL)
+		if err := checkTTL(ttl); err != nil {
+			return err
+		}
+		if err := c.validate(c.key(key), obj); err != nil {
+			return err
+		}
+		objs[key] = obj
+		ttls[key] = ttl
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, c.key(key))
+	}
+	unlockWrites := c.lockWrites(keys...)
+	for key := range entries {
+		if err := c.write(c.extKey(key), objs[key], ttls[key]); err != nil {
+			unlockWrites()
+			return err
+		}
+	}
+
+	c.mtx.Lock()
+	for key := range entries {
+		c.set(c.key(key), objs[key], ttls[key])
+	}
+	unlockWrites()
+	c.unlock()
+
+	return nil
 }
 
-// WARNING: If value is in SliceType or MapType, the operation on the returned value is not thread-safe.
-func Get[T ValType](c *Cache, key string) (retV T, retErr error) {
-	var item Item
-	var exists bool
+// Set a value of any type. Prefer Set, custom types must be registered with
+// RegisterType to be persisted, and CopyOnSet copies their slices and maps
+// only shallowly. With TypeCheck, unregistered types are rejected with
+// ErrInvalidType, as well as writes rejected by MaxKeyLen, the Validator or
+// the Writer.
+func SetAny(c *Cache, key string, val interface{}, ttl time.Duration) error {
+	extKey, key := c.extKey(key), c.key(key)
 
-	c.mtx.RLock()
-	item, exists = c.persistItems[key]
-	if !exists {
-		item, exists = c.volatileItems[key]
-		if !exists || item.expired() {
-			c.mtx.RUnlock()
-			retErr = ErrNotExists
-			return
+	if err := checkTTL(ttl); err != nil {
+		return err
+	}
+	if err := c.validate(key, val); err != nil {
+		return err
+	}
+	if c.opts.typeCheck {
+		if err := checkRegistered(val); err != nil {
+			return err
 		}
 	}
-	c.mtx.RUnlock()
 
-	v, ok := item.Object.(T)
-	if !ok {
-		retErr = ErrInvalidType
-		return
+	unlockWrites := c.lockWrites(key)
+	if err := c.write(extKey, val, ttl); err != nil {
+		unlockWrites()
+		return err
 	}
 
-	return v, nil
+	c.mtx.Lock()
+	c.set(key, val, ttl)
+	unlockWrites()
+	c.unlock()
+
+	return nil
 }
 
-func GetTTL(c *Cache, key string) (time.Duration, error) {
-	c.mtx.RLock()
-	defer c.mtx.RUnlock()
+// Set the value to expire at an absolute time, a zero expireAt means never
+// expire. An expireAt in the past still replaces the value, which is then
+// immediately expired. Like Set, it drops writes rejected by MaxKeyLen or the
+// Validator. The Writer isn't called.
+func SetAt[T ValType](c *Cache, key string, val T, expireAt time.Time) {
+	key = c.key(key)
 
-	item, exists := c.persistItems[key]
-	if exists {
-		return NEVER_EXPIRE, nil
+	expireMs := kNeverExpireMs
+	if !expireAt.IsZero() {
+		expireMs = c.quantize(expireAt.UnixMilli())
 	}
 
-	item, exists = c.volatileItems[key]
-	if !exists {
-		return 0, ErrNotExists
+	var obj interface{} = val
+	if c.validate(key, obj) != nil {
+		return
 	}
-
-	nowMs := time.Now().UnixMilli()
-	if item.ExpireMs < nowMs {
-		return 0, ErrNotExists
-	} else {
-		return time.Duration(item.ExpireMs-nowMs) * time.Millisecond, nil
+	if c.opts.copyOnSet {
+		obj = cloneObject(obj)
 	}
+
+	c.mtx.Lock()
+	c.store(key, obj, expireMs)
+	c.unlock()
 }
 
-func GetWithTTL[T ValType](c *Cache, key string) (T, time.Duration, error) {
-	var t T
-	c.mtx.RLock()
-	defer c.mtx.RUnlock()
+func Delete(c *Cache, key string) {
+	key = c.key(key)
 
-	item, exists := c.persistItems[key]
-	if exists {
-		if v, ok := item.Object.(T); !ok {
-			return t, 0, ErrInvalidType
-		} else {
-			return v, NEVER_EXPIRE, nil
-		}
+	c.mtx.Lock()
+	if item, existed := c.persistItems[key]; existed {
+		delete(c.persistItems, key)
+		c.changes++
+		c.record(key, EventDel, item)
+	} else if item, existed := c.volatileItems[key]; existed {
+		delete(c.volatileItems, key)
+		c.stopTimer(key)
+		c.changes++
+		c.record(key, EventDel, item)
 	}
+	c.notifyEmpty()
+	c.unlock()
+}
 
-	item, exists = c.volatileItems[key]
-	if !exists {
-		return t, 0, ErrNotExists
-	}
+// Get the value and delete the key in one step, so no one else can read it.
+// The key is kept on ErrInvalidType.
+func GetAndDelete[T ValType](c *Cache, key string) (T, error) {
+	key = c.key(key)
 
-	v, ok := item.Object.(T)
-	if !ok {
-		return t, 0, ErrInvalidType
-	}
+	var retV T
 
-	nowMs := time.Now().UnixMilli()
-	if item.ExpireMs < nowMs {
-		return t, 0, ErrNotExists
-	} else {
-		return v, time.Duration(item.ExpireMs-nowMs) * time.Millisecond, nil
+	c.mtx.Lock()
+	defer c.unlock()
+
+	obj, err := c.take(key, func(obj interface{}) bool {
+		_, ok := obj.(T)
+		return ok
+	})
+	if err != nil {
+		return retV, err
 	}
-}
 
-// Note: thread-safe but expensive
-func GetSliceCopy[T ScalarType](c *Cache, key string) (retV []T, retErr error) {
-	var item Item
-	var exists bool
+	return obj.(T), nil
+}
 
-	c.mtx.RLock()
-	defer c.mtx.RUnlock()
+// DeleteKeys deletes the keys and returns the number of unexpired keys
+// deleted, firing an EventDel for each unexpired key removed and an
+// EventExpired for each expired one.
+func DeleteKeys(c *Cache, keys []string) int {
+	c.mtx.Lock()
+	defer c.unlock()
 
-	item, exists = c.persistItems[key]
-	if !exists {
-		item, exists = c.volatileItems[key]
-		if !exists || item.expired() {
-			c.mtx.RUnlock()
-			retErr = ErrNotExists
-			return
+	n := 0
+	nowMs := c.nowMs()
+	for _, key := range keys {
+		key = c.key(key)
+		item, existed := c.persistItems[key]
+		if !existed {
+			item, existed = c.volatileItems[key]
+		}
+		if existed {
+			delete(c.persistItems, key)
+			delete(c.volatileItems, key)
+			c.stopTimer(key)
+			c.changes++
+			c.record(key, EventDel, item)
+			if !item.expiredAt(nowMs) {
+				n++
+			}
 		}
 	}
+	c.notifyEmpty()
 
-	vv, ok := item.Object.([]T)
-	if !ok {
-		retErr = ErrInvalidType
-		return
+	return n
+}
+
+// DeleteMatch deletes the keys matching pattern, a path.Match pattern such as
+// "session:*:temp", in one step, and returns the number of unexpired keys
+// deleted, firing an EventDel for each unexpired key removed and an
+// EventExpired for each expired one. Malformed patterns fail with
+// path.ErrBadPattern.
+func DeleteMatch(c *Cache, pattern string) (int, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return 0, err
 	}
 
-	retV = make([]T, len(vv))
-	copy(retV, vv)
+	c.mtx.Lock()
+	defer c.unlock()
+
+	n := 0
+	nowMs := c.nowMs()
+	for _, items := range []map[string]Item{c.persistItems, c.volatileItems} {
+		for key, item := range items {
+			own, ok := c.ownKey(key)
+			if !ok {
+				continue
+			}
+			if matched, _ := path.Match(pattern, own); !matched {
+				continue
+			}
+			delete(items, key)
+			c.stopTimer(key)
+			c.changes++
+			c.record(key, EventDel, item)
+			if !item.expiredAt(nowMs) {
+				n++
+			}
+		}
+	}
+	c.notifyEmpty()
 
-	return
+	return n, nil
 }
 
-// Note: Thread-safe but expensive
-func GetMapCopy[T ScalarType](c *Cache, key string) (retV map[string]T, retErr error) {
+// Rename moves the item of oldKey to newKey, keeping its expiration and
+// overwriting any existing newKey. Fails like SetChecked if newKey is
+// rejected, the Validator then runs with the cache locked.
+func Rename(c *Cache, oldKey, newKey string) error {
+	oldKey, newKey = c.key(oldKey), c.key(newKey)
+
 	var item Item
 	var exists bool
 
-	c.mtx.RLock()
-	defer c.mtx.RUnlock()
+	c.mtx.Lock()
+	defer c.unlock()
 
-	item, exists = c.persistItems[key]
+	item, exists = c.persistItems[oldKey]
 	if !exists {
-		item, exists = c.volatileItems[key]
-		if !exists || item.expired() {
-			c.mtx.RUnlock()
-			retErr = ErrNotExists
-			return
+		item, exists = c.volatileItems[oldKey]
+		if !exists || c.expired(item) {
+			return ErrNotExists
 		}
 	}
 
-	vv, ok := item.Object.(map[string]T)
-	if !ok {
-		retErr = ErrInvalidType
-		return
+	if oldKey == newKey {
+		return nil
 	}
-
-	retV = make(map[string]T)
-	for k, v := range vv {
-		retV[k] = v
+	if err := c.validate(newKey, item.Object); err != nil {
+		return err
 	}
 
-	return
-}
+	delete(c.persistItems, oldKey)
+	delete(c.volatileItems, oldKey)
+	c.stopTimer(oldKey)
+	c.record(oldKey, EventDel, item)
+	c.store(newKey, item.Object, item.ExpireMs)
 
-func Set[T ValType](c *Cache, key string, val T, ttl time.Duration) {
-	c.mtx.Lock()
-	if ttl == NEVER_EXPIRE {
-		delete(c.volatileItems, key)
-		c.persistItems[key] = Item{
-			Object:   val,
-			ExpireMs: kNeverExpireMs,
-		}
-	} else {
-		delete(c.persistItems, key)
-		c.volatileItems[key] = Item{
-			Object:   val,
-			ExpireMs: time.Now().Add(ttl).UnixMilli(),
-		}
-	}
-	c.changed = true
-	c.mtx.Unlock()
+	return nil
 }
 
-func Delete(c *Cache, key string) {
-	c.mtx.Lock()
-	if _, existed := c.persistItems[key]; existed {
-		delete(c.persistItems, key)
-		c.changed = true
-	} else if _, existed := c.volatileItems[key]; existed {
-		delete(c.volatileItems, key)
-		c.changed = true
+// Update atomically replaces the value by fn(old, existed), where old is the
+// zero value if the key doesn't exist. Nothing is stored if fn returns an
+// error, or if the new value is rejected like by SetChecked. fn and the
+// Validator run with the cache locked and must not call back into it. The
+// Writer isn't called.
+func Update[T ValType](c *Cache, key string, fn func(old T, existed bool) (T, error), ttl time.Duration) (T, error) {
+	key = c.key(key)
+
+	var old T
+
+	if err := checkTTL(ttl); err != nil {
+		return old, err
 	}
-	c.mtx.Unlock()
-}
 
-func DeleteKeys(c *Cache, keys []string) {
 	c.mtx.Lock()
-	defer c.mtx.Unlock()
+	defer c.unlock()
 
-	for _, key := range keys {
-		delete(c.persistItems, key)
-		delete(c.volatileItems, key)
+	item, exists := c.persistItems[key]
+	if !exists {
+		item, exists = c.volatileItems[key]
+		exists = exists && !c.expired(item)
+	}
+	if exists {
+		v, ok := item.Object.(T)
+		if !ok {
+			return old, invalidTypeError(item.Object)
+		}
+		old = v
+	}
+
+	newV, err := fn(old, exists)
+	if err != nil {
+		return old, err
+	}
+	if err := c.validate(key, newV); err != nil {
+		return old, err
 	}
+	c.set(key, newV, ttl)
+
+	return newV, nil
 }
 
+// Increase adds val to the number stored at key and returns the result. Fails
+// with ErrNotExists if the key doesn't exist. The Writer isn't called.
 func Increase[T NumType](c *Cache, key string, val T) (T, error) {
+	key = c.key(key)
+
 	var retVal T
 	var item Item
 	var exists bool
 
 	c.mtx.Lock()
-	defer c.mtx.Unlock()
+	defer c.unlock()
 
 	item, exists = c.persistItems[key]
 	if !exists {
 		item, exists = c.volatileItems[key]
-		if !exists || item.expired() {
+		if !exists || c.expired(item) {
 			return retVal, ErrNotExists
 		}
 	}
 
 	oldV, ok := item.Object.(T)
 	if !ok {
-		return retVal, ErrInvalidType
+		return retVal, invalidTypeError(item.Object)
 	}
 
 	newV := oldV + val
 	item.Object = newV
 
-	if item.neverExpire() {
-		c.persistItems[key] = item
-	} else {
-		c.volatileItems[key] = item
-	}
-	c.changed = true
+	c.put(key, item)
 
 	return newV, nil
 }
 
+// Decrease subtracts val from the number and returns the result, like
+// Increase. The Writer isn't called.
 func Decrease[T NumType](c *Cache, key string, val T) (T, error) {
+	key = c.key(key)
+
 	var retVal T
 	var item Item
 	var exists bool
 
 	c.mtx.Lock()
-	defer c.mtx.Unlock()
+	defer c.unlock()
 
 	item, exists = c.persistItems[key]
 	if !exists {
 		item, exists = c.volatileItems[key]
-		if !exists || item.expired() {
+		if !exists || c.expired(item) {
 			return retVal, ErrNotExists
 		}
 	}
 
 	oldV, ok := item.Object.(T)
 	if !ok {
-		return retVal, ErrInvalidType
+		return retVal, invalidTypeError(item.Object)
 	}
 
 	newV := oldV - val
 	item.Object = newV
 
-	if item.neverExpire() {
-		c.persistItems[key] = item
-	} else {
-		c.volatileItems[key] = item
-	}
-	c.changed = true
+	c.put(key, item)
 
 	return newV, nil
 }
 
-// Append scalar to an existing slice cache
+// Append scalar to an existing slice cache. The slice is copied on append, so
+// slices returned by Get before stay valid and unchanged, at the cost of
+// copying the whole slice on every append. The Writer isn't called.
 func AppendToSlice[T ScalarType](c *Cache, key string, val T) error {
+	key = c.key(key)
+
 	var item Item
 	var exists bool
 
 	c.mtx.Lock()
-	defer c.mtx.Unlock()
+	defer c.unlock()
 
 	item, exists = c.persistItems[key]
 	if !exists {
 		item, exists = c.volatileItems[key]
-		if !exists || item.expired() {
+		if !exists || c.expired(item) {
 			return ErrNotExists
 		}
 	}
 
 	valSlice, ok := item.Object.([]T)
 	if !ok {
-		return ErrInvalidType
+		return invalidTypeError(item.Object)
 	}
-	valSlice = append(valSlice, val)
-	item.Object = valSlice
+	newSlice := make([]T, len(valSlice)+1)
+	copy(newSlice, valSlice)
+	newSlice[len(valSlice)] = val
+	item.Object = newSlice
 
-	if item.neverExpire() {
-		c.persistItems[key] = item
-	} else {
-		c.volatileItems[key] = item
-	}
-	c.changed = true
+	c.put(key, item)
 
 	return nil
 }
 
 // Insert scalar to an existing map cache
 func InsertToMap[T ScalarType](c *Cache, key string, name string, val T) error {
+	key = c.key(key)
+
 	var item Item
 	var exists bool
 
 	c.mtx.Lock()
-	defer c.mtx.Unlock()
+	defer c.unlock()
 
 	item, exists = c.persistItems[key]
 	if !exists {
 		item, exists = c.volatileItems[key]
-		if !exists || item.expired() {
+		if !exists || c.expired(item) {
 			return ErrNotExists
 		}
 	}
 
 	valMap, ok := item.Object.(map[string]T)
 	if !ok {
-		return ErrInvalidType
+		return invalidTypeError(item.Object)
 	}
 	valMap[name] = val
 
-	if item.neverExpire() {
-		c.persistItems[key] = item
-	} else {
-		c.volatileItems[key] = item
-	}
-	c.changed = true
+	c.put(key, item)
 
 	return nil
 }
 
 // Delete value from an existing map
 func DeleteFromMap[T ScalarType](c *Cache, key string, name string) error {
+	key = c.key(key)
+
 	var item Item
 	var exists bool
 
 	c.mtx.Lock()
-	defer c.mtx.Unlock()
+	defer c.unlock()
 
 	item, exists = c.persistItems[key]
 	if !exists {
 		item, exists = c.volatileItems[key]
-		if !exists || item.expired() {
+		if !exists || c.expired(item) {
 			return ErrNotExists
 		}
 	}
 
 	valMap, ok := item.Object.(map[string]T)
 	if !ok {
-		return ErrInvalidType
+		return invalidTypeError(item.Object)
 	}
 	delete(valMap, name)
+	c.put(key, item)
 
 	return nil
 }
 
+// Keys returns the keys of all unexpired items
 func Keys(c *Cache) []string {
 	c.mtx.RLock()
 	defer c.mtx.RUnlock()
 
 	keys := make([]string, 0, len(c.persistItems)+len(c.volatileItems))
-	for k := range c.persistItems {
-		keys = append(keys, k)
+	for key := range c.persistItems {
+		if k, own := c.ownKey(key); own {
+			keys = append(keys, k)
+		}
+	}
+	for key, item := range c.volatileItems {
+		if k, own := c.ownKey(key); own && !c.expired(item) {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+// SortedKeys returns the keys of all unexpired items in lexicographic order,
+// e.g. for reproducible output. Keys is cheaper when the order doesn't matter.
+// The keys are sorted without the lock held.
+func SortedKeys(c *Cache) []string {
+	keys := Keys(c)
+	sort.Strings(keys)
+	return keys
+}
+
+// Scan returns a page of about count unexpired keys starting at cursor, 0 for
+// the first page, and the cursor of the next page, 0 once done. Keys are
+// ordered by hash, so every key existing during the whole scan is returned
+// exactly once however the cache changes meanwhile; keys written during the
+// scan may or may not be returned.
+func Scan(c *Cache, cursor uint64, count int) (keys []string, next uint64) {
+	n := count
+	if n < 1 {
+		n = 1
+	}
+
+	// keep the n smallest hashes at or above cursor in a max-heap, so a page
+	// costs O(N log count) rather than sorting all keys after cursor
+	var page hashHeap
+	more := false
+	c.mtx.RLock()
+	nowMs := c.nowMs()
+	add := func(key string) {
+		k, own := c.ownKey(key)
+		if !own {
+			return
+		}
+		h := hashKey(k)
+		if h < cursor {
+			return
+		}
+		if len(page) >= n && h > page[0].hash {
+			more = true
+			return
+		}
+		heap.Push(&page, hashedKey{hash: h, key: k})
+		for len(page) > n {
+			top := heap.Pop(&page).(hashedKey)
+			if page[0].hash != top.hash {
+				more = true
+				continue
+			}
+			// keys sharing a hash never straddle two pages
+			ties := []hashedKey{top}
+			for len(page) > 0 && page[0].hash == top.hash {
+				ties = append(ties, heap.Pop(&page).(hashedKey))
+			}
+			if len(page) >= n {
+				more = true
+				continue
+			}
+			for _, t := range ties {
+				heap.Push(&page, t)
+			}
+			break
+		}
+	}
+	for key := range c.persistItems {
+		add(key)
+	}
+	for key, item := range c.volatileItems {
+		if !item.expiredAt(nowMs) {
+			add(key)
+		}
+	}
+	c.mtx.RUnlock()
+
+	sort.Slice(page, func(i, j int) bool {
+		return page[i].hash < page[j].hash
+	})
+	if more {
+		next = page[len(page)-1].hash + 1
+	}
+
+	keys = make([]string, len(page))
+	for i := range keys {
+		keys[i] = page[i].key
+	}
+
+	return keys, next
+}
+
+type hashedKey struct {
+	hash uint64
+	key  string
+}
+
+// hashHeap is a max-heap of keys by hash, see Scan.
+type hashHeap []hashedKey
+
+func (h hashHeap) Len() int            { return len(h) }
+func (h hashHeap) Less(i, j int) bool  { return h[i].hash > h[j].hash }
+func (h hashHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *hashHeap) Push(x interface{}) { *h = append(*h, x.(hashedKey)) }
+
+func (h *hashHeap) Pop() interface{} {
+	old := *h
+	k := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return k
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// Range calls fn for each unexpired item until fn returns false. fn is called
+// without the lock held, so it may use the cache, but as each value is read
+// right before its call, fn may observe a mix of old and new values while
+// other goroutines write. Use RangeSnapshot for a consistent view.
+func Range(c *Cache, fn func(key string, val interface{}) bool) {
+	for _, key := range Keys(c) {
+		k := c.extKey(key) // keys are stored transformed already
+		c.mtx.RLock()
+		item, exists := c.persistItems[k]
+		if !exists {
+			item, exists = c.volatileItems[k]
+			exists = exists && !c.expired(item)
+		}
+		c.mtx.RUnlock()
+
+		if exists && !fn(key, item.Object) {
+			return
+		}
+	}
+}
+
+// RangeSnapshot calls fn for each item unexpired at the time of the call until
+// fn returns false, with the values as of that same point in time. It copies
+// all the items at once, trading memory for consistency; slice and map values
+// are shared, not copied.
+func RangeSnapshot(c *Cache, fn func(key string, val interface{}) bool) {
+	c.mtx.RLock()
+	nowMs := c.nowMs()
+	items := make(map[string]interface{}, len(c.persistItems)+len(c.volatileItems))
+	for key, item := range c.persistItems {
+		if k, own := c.ownKey(key); own {
+			items[k] = item.Object
+		}
+	}
+	for key, item := range c.volatileItems {
+		if k, own := c.ownKey(key); own && !item.expiredAt(nowMs) {
+			items[k] = item.Object
+		}
+	}
+	c.mtx.RUnlock()
+
+	for key, val := range items {
+		if !fn(key, val) {
+			return
+		}
+	}
+}
+
+// RandomKeys returns up to n randomly chosen unexpired keys, sampled with a
+// reservoir so the keyspace is never copied.
+func RandomKeys(c *Cache, n int) []string {
+	if n <= 0 {
+		return nil
+	}
+
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	keys := make([]string, 0, n)
+	seen := 0
+	sample := func(key string) {
+		k, own := c.ownKey(key)
+		if !own {
+			return
+		}
+		seen++
+		if len(keys) < n {
+			keys = append(keys, k)
+		} else if i := rand.Intn(seen); i < n {
+			keys[i] = k
+		}
+	}
+
+	for key := range c.persistItems {
+		sample(key)
 	}
-	for k := range c.volatileItems {
-		keys = append(keys, k)
+	nowMs := c.nowMs()
+	for key, item := range c.volatileItems {
+		if !item.expiredAt(nowMs) {
+			sample(key)
+		}
 	}
 
 	return keys
@@ -404,21 +1481,42 @@ func Keys(c *Cache) []string {
 // could all items which may include the expired items
 func Len(c *Cache) int {
 	c.mtx.RLock()
-	n1 := len(c.persistItems)
-	n2 := len(c.volatileItems)
-	c.mtx.RUnlock()
+	defer c.mtx.RUnlock()
 
-	return n1 + n2
+	if c.prefix == "" {
+		return len(c.persistItems) + len(c.volatileItems)
+	}
+
+	n := 0
+	for key := range c.persistItems {
+		if _, own := c.ownKey(key); own {
+			n++
+		}
+	}
+	for key := range c.volatileItems {
+		if _, own := c.ownKey(key); own {
+			n++
+		}
+	}
+	return n
 }
 
 // count only unexpired items, more expensive than TotalItems
 func LenValid(c *Cache) int {
 	c.mtx.RLock()
 	n1 := len(c.persistItems)
+	if c.prefix != "" {
+		n1 = 0
+		for key := range c.persistItems {
+			if _, own := c.ownKey(key); own {
+				n1++
+			}
+		}
+	}
 
 	n2 := 0
-	for _, item := range c.volatileItems {
-		if !item.expired() {
+	for key, item := range c.volatileItems {
+		if _, own := c.ownKey(key); own && !c.expired(item) {
 			n2++
 		}
 	}
@@ -426,3 +1524,85 @@ func LenValid(c *Cache) int {
 
 	return n1 + n2
 }
+
+// count unexpired volatile items expiring within d
+func ExpiringWithin(c *Cache, d time.Duration) int {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	nowMs := c.nowMs()
+	maxExpireMs := nowMs + d.Milliseconds()
+
+	n := 0
+	for key, item := range c.volatileItems {
+		if _, own := c.ownKey(key); own && !item.expiredAt(nowMs) && item.ExpireMs <= maxExpireMs {
+			n++
+		}
+	}
+
+	return n
+}
+
+// KeysExpiringBetween returns the unexpired keys expiring within [from, to],
+// soonest first, e.g. to refresh them before they lapse. Persistent keys
+// never expire so they are never returned.
+func KeysExpiringBetween(c *Cache, from, to time.Time) []string {
+	type expiring struct {
+		key      string
+		expireMs int64
+	}
+
+	c.mtx.RLock()
+	nowMs := c.nowMs()
+	fromMs, toMs := from.UnixMilli(), to.UnixMilli()
+
+	var found []expiring
+	for key, item := range c.volatileItems {
+		if item.expiredAt(nowMs) || item.ExpireMs < fromMs || item.ExpireMs > toMs {
+			continue
+		}
+		if own, ok := c.ownKey(key); ok {
+			found = append(found, expiring{key: own, expireMs: item.ExpireMs})
+		}
+	}
+	c.mtx.RUnlock()
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].expireMs != found[j].expireMs {
+			return found[i].expireMs < found[j].expireMs
+		}
+		return found[i].key < found[j].key
+	})
+
+	keys := make([]string, len(found))
+	for i, e := range found {
+		keys[i] = e.key
+	}
+	return keys
+}
+
+// TTLHistogram counts unexpired volatile items by remaining TTL. buckets are
+// ascending upper bounds, item i of the result counts the TTLs in
+// (buckets[i-1], buckets[i]] and the extra last item the TTLs beyond the last
+// bucket.
+func TTLHistogram(c *Cache, buckets []time.Duration) []int {
+	counts := make([]int, len(buckets)+1)
+
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	nowMs := c.nowMs()
+	for key, item := range c.volatileItems {
+		if _, own := c.ownKey(key); !own || item.expiredAt(nowMs) {
+			continue
+		}
+
+		ttl := time.Duration(item.ExpireMs-nowMs) * time.Millisecond
+		i := sort.Search(len(buckets), func(i int) bool {
+			return ttl <= buckets[i]
+		})
+		counts[i]++
+	}
+
+	return counts
+}
@@ -0,0 +1,1625 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSliceIndex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, time.Second*2, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", []int{1, 2, 3}, NEVER_EXPIRE)
+
+	v, err := SliceIndex[int](c, "k1", 1)
+	if err != nil {
+		t.Error(err)
+		return
+	} else if v != 2 {
+		t.Errorf("value error. key: k1, index: 1, expect: 2, got: %v", v)
+		return
+	}
+
+	if _, err := SliceIndex[int](c, "k1", -1); err != ErrIndexOutOfRange {
+		t.Errorf("negative index, expect: %v, got: %v", ErrIndexOutOfRange, err)
+		return
+	}
+
+	if _, err := SliceIndex[int](c, "k1", 3); err != ErrIndexOutOfRange {
+		t.Errorf("out-of-bounds index, expect: %v, got: %v", ErrIndexOutOfRange, err)
+		return
+	}
+
+	if _, err := SliceIndex[string](c, "k1", 0); !errors.Is(err, ErrInvalidType) {
+		t.Errorf("mismatched type, expect: %v, got: %v", ErrInvalidType, err)
+		return
+	}
+
+	if _, err := SliceIndex[int](c, "k2", 0); err != ErrNotExists {
+		t.Errorf("missing key, expect: %v, got: %v", ErrNotExists, err)
+		return
+	}
+}
+
+func TestGetCopyMissing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, time.Second*2, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	if _, err := GetSliceCopy[int](c, "k1"); err != ErrNotExists {
+		t.Errorf("GetSliceCopy on missing key, expect: %v, got: %v", ErrNotExists, err)
+		return
+	}
+
+	if _, err := GetMapCopy[int](c, "k1"); err != ErrNotExists {
+		t.Errorf("GetMapCopy on missing key, expect: %v, got: %v", ErrNotExists, err)
+		return
+	}
+}
+
+func TestGetSliceInto(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, time.Second*2, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", []int{1, 2, 3}, NEVER_EXPIRE)
+
+	buf := make([]int, 0, 8)
+	v, err := GetSliceInto(c, "k1", buf)
+	if err != nil || len(v) != 3 || v[2] != 3 {
+		t.Errorf("invalid slice, got: %v, %v", v, err)
+		return
+	}
+	if &v[0] != &buf[:1][0] {
+		t.Error("buffer not reused")
+		return
+	}
+
+	v[0] = 10
+	if vv, _ := Get[[]int](c, "k1"); vv[0] != 1 {
+		t.Error("cached slice mutated through the buffer")
+		return
+	}
+
+	// grows a small buffer
+	if v, err := GetSliceInto(c, "k1", make([]int, 0, 1)); err != nil || len(v) != 3 {
+		t.Errorf("invalid slice, got: %v, %v", v, err)
+		return
+	}
+
+	if v, err := GetSliceInto(c, "k2", v); err != ErrNotExists || len(v) != 0 {
+		t.Errorf("missing key, expect: %v, got: %v, %v", ErrNotExists, v, err)
+		return
+	}
+}
+
+func TestSetCAS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, time.Second*2, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", []string{"a"}, NEVER_EXPIRE)
+
+	v, token, err := GetCAS[[]string](c, "k1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if ok, err := SetCAS(c, "k1", append(v, "b"), token, NEVER_EXPIRE); err != nil {
+		t.Error(err)
+		return
+	} else if !ok {
+		t.Error("fresh token rejected")
+		return
+	}
+
+	// the successful SetCAS invalidates the token
+	if ok, err := SetCAS(c, "k1", []string{"c"}, token, NEVER_EXPIRE); err != nil {
+		t.Error(err)
+		return
+	} else if ok {
+		t.Error("stale token accepted")
+		return
+	}
+
+	_, token, err = GetCAS[[]string](c, "k1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// interleaved write
+	AppendToSlice(c, "k1", "d")
+
+	if ok, err := SetCAS(c, "k1", []string{"e"}, token, NEVER_EXPIRE); err != nil {
+		t.Error(err)
+		return
+	} else if ok {
+		t.Error("token not invalidated by interleaved write")
+		return
+	}
+
+	v, err = Get[[]string](c, "k1")
+	if err != nil {
+		t.Error(err)
+		return
+	} else if len(v) != 3 || v[2] != "d" {
+		t.Errorf("value error. key: k1, expect: [a b d], got: %v", v)
+		return
+	}
+}
+
+func TestKeys(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+	Set(c, "k2", "v2", time.Minute)
+	Set(c, "k3", "v3", time.Millisecond*10)
+	time.Sleep(time.Millisecond * 20)
+
+	keys := Keys(c)
+	if len(keys) != 2 {
+		t.Errorf("invalid number of keys, expect: 2, got: %v", keys)
+		return
+	}
+	for _, k := range keys {
+		if _, err := Get[string](c, k); err != nil {
+			t.Errorf("key %v not readable: %v", k, err)
+			return
+		}
+	}
+
+	Set(c, "a0", "v0", time.Minute)
+	if keys := SortedKeys(c); fmt.Sprint(keys) != "[a0 k1 k2]" {
+		t.Errorf("invalid sorted keys, expect: [a0 k1 k2], got: %v", keys)
+		return
+	}
+}
+
+func TestGetAndDelete(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", "v1", time.Minute)
+	Set(c, "k2", 2, NEVER_EXPIRE)
+
+	if _, err := GetAndDelete[int](c, "k1"); !errors.Is(err, ErrInvalidType) {
+		t.Errorf("mismatched type, expect: %v, got: %v", ErrInvalidType, err)
+		return
+	}
+
+	v1, err := GetAndDelete[string](c, "k1")
+	if err != nil {
+		t.Error(err)
+		return
+	} else if v1 != "v1" {
+		t.Errorf("value error. key: k1, expect: v1, got: %v", v1)
+		return
+	}
+	if _, err := GetAndDelete[string](c, "k1"); err != ErrNotExists {
+		t.Errorf("deleted key, expect: %v, got: %v", ErrNotExists, err)
+		return
+	}
+
+	v2, err := c.GetAndDelete("k2")
+	if err != nil {
+		t.Error(err)
+		return
+	} else if v2 != 2 {
+		t.Errorf("value error. key: k2, expect: 2, got: %v", v2)
+		return
+	}
+	if n := Len(c); n != 0 {
+		t.Errorf("invalid number of items, expect: 0, got: %v", n)
+		return
+	}
+}
+
+func TestSetAt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	expireAt := time.Now().Add(time.Minute)
+	SetAt(c, "k1", "v1", expireAt)
+	if ttl, err := GetTTL(c, "k1"); err != nil {
+		t.Error(err)
+		return
+	} else if ttl <= time.Second*58 || ttl > time.Minute {
+		t.Errorf("ttl error. key: k1, got: %v", ttl)
+		return
+	}
+
+	SetAt(c, "k2", "v2", time.Time{})
+	if ttl, err := GetTTL(c, "k2"); err != nil {
+		t.Error(err)
+		return
+	} else if ttl != NEVER_EXPIRE {
+		t.Errorf("ttl error. key: k2, expect: %v, got: %v", NEVER_EXPIRE, ttl)
+		return
+	}
+
+	SetAt(c, "k2", "v3", time.Now().Add(-time.Second))
+	if _, err := Get[string](c, "k2"); err != ErrNotExists {
+		t.Errorf("past expiry, expect: %v, got: %v", ErrNotExists, err)
+		return
+	}
+}
+
+func TestRename(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "tmp:abc", "v1", time.Minute)
+	Set(c, "final:abc", "v0", NEVER_EXPIRE)
+
+	if err := Rename(c, "tmp:abc", "final:abc"); err != nil {
+		t.Error(err)
+		return
+	}
+	if Exists(c, "tmp:abc") {
+		t.Error("old key exists after rename")
+		return
+	}
+
+	v, ttl, err := GetWithTTL[string](c, "final:abc")
+	if err != nil {
+		t.Error(err)
+		return
+	} else if v != "v1" {
+		t.Errorf("value error. key: final:abc, expect: v1, got: %v", v)
+		return
+	} else if ttl <= 0 || ttl > time.Minute {
+		t.Errorf("ttl not kept. key: final:abc, got: %v", ttl)
+		return
+	}
+
+	if err := Rename(c, "tmp:abc", "final:abc"); err != ErrNotExists {
+		t.Errorf("missing key, expect: %v, got: %v", ErrNotExists, err)
+		return
+	}
+}
+
+func TestInvalidTypeError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", int64(1), NEVER_EXPIRE)
+
+	_, err = Get[string](c, "k1")
+	if !errors.Is(err, ErrInvalidType) {
+		t.Errorf("expect: %v, got: %v", ErrInvalidType, err)
+		return
+	} else if err.Error() != "invalid type: stored as int64" {
+		t.Errorf("stored type missing in error: %v", err)
+		return
+	}
+
+	if _, err := Increase(c, "k1", int32(1)); !errors.Is(err, ErrInvalidType) {
+		t.Errorf("expect: %v, got: %v", ErrInvalidType, err)
+		return
+	}
+}
+
+func TestGetOrDefault(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+
+	if v := GetOrDefault(c, "k1", "def"); v != "v1" {
+		t.Errorf("value error. key: k1, expect: v1, got: %v", v)
+		return
+	}
+	if v := GetOrDefault(c, "k2", "def"); v != "def" {
+		t.Errorf("missing key, expect: def, got: %v", v)
+		return
+	}
+	if v := GetOrDefault(c, "k1", 3); v != 3 {
+		t.Errorf("mismatched type, expect: 3, got: %v", v)
+		return
+	}
+}
+
+func TestGetWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+	if v, err := GetWait[string](ctx, c, "k1"); err != nil {
+		t.Error(err)
+		return
+	} else if v != "v1" {
+		t.Errorf("value error. key: k1, expect: v1, got: %v", v)
+		return
+	}
+
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		Set(c, "k2", "v2", time.Minute)
+	}()
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, time.Second)
+	defer waitCancel()
+
+	if v, err := GetWait[string](waitCtx, c, "k2"); err != nil {
+		t.Error(err)
+		return
+	} else if v != "v2" {
+		t.Errorf("value error. key: k2, expect: v2, got: %v", v)
+		return
+	}
+
+	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, time.Millisecond*20)
+	defer timeoutCancel()
+
+	if _, err := GetWait[string](timeoutCtx, c, "k3"); err != context.DeadlineExceeded {
+		t.Errorf("expect: %v, got: %v", context.DeadlineExceeded, err)
+		return
+	}
+
+	// a cancelled wait doesn't leave its key behind, nor drop other waiters
+	got := make(chan string, 1)
+	go func() {
+		v, _ := GetWait[string](waitCtx, c, "k4")
+		got <- v
+	}()
+	cancelCtx, cancelWait := context.WithCancel(ctx)
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		cancelWait()
+	}()
+	if _, err := GetWait[string](cancelCtx, c, "k4"); err != context.Canceled {
+		t.Errorf("expect: %v, got: %v", context.Canceled, err)
+		return
+	}
+	Set(c, "k4", "v4", NEVER_EXPIRE)
+	if v := <-got; v != "v4" {
+		t.Errorf("value error. key: k4, expect: v4, got: %v", v)
+		return
+	}
+
+	c.mtx.RLock()
+	n := len(c.waiters)
+	c.mtx.RUnlock()
+	if n != 0 {
+		t.Errorf("waiters left behind: %v", n)
+		return
+	}
+}
+
+func TestGetCopy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", []int{1, 2, 3}, NEVER_EXPIRE)
+	Set(c, "k2", map[string]string{"a": "x"}, NEVER_EXPIRE)
+	Set(c, "k3", int64(3), NEVER_EXPIRE)
+
+	v1, err := GetCopy[[]int](c, "k1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	v1[0] = 100
+	if v, _ := SliceIndex[int](c, "k1", 0); v != 1 {
+		t.Errorf("slice not copied, expect: 1, got: %v", v)
+		return
+	}
+
+	v2, err := GetCopy[map[string]string](c, "k2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	v2["a"] = "y"
+	if v, _ := Get[map[string]string](c, "k2"); v["a"] != "x" {
+		t.Errorf("map not copied, expect: x, got: %v", v["a"])
+		return
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		GetCopy[int64](c, "k3")
+	})
+	if allocs != 0 {
+		t.Errorf("scalar copy allocates, got: %v", allocs)
+		return
+	}
+}
+
+type testPoint struct {
+	X, Y int
+}
+
+func TestSetAny(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	RegisterForPersistence(testPoint{}, []testPoint{}, map[string]testPoint{})
+	persister := &FilePersister{FilePath: "persist_any.bin"}
+	defer os.Remove(persister.FilePath)
+
+	c, err := New(ctx, 0, 0, persister)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+
+	SetAny(c, "k1", testPoint{1, 2}, NEVER_EXPIRE)
+	SetAny(c, "k2", []testPoint{{3, 4}}, NEVER_EXPIRE)
+	SetAny(c, "k3", map[string]testPoint{"a": {5, 6}}, NEVER_EXPIRE)
+	if _, err := GetAny[string](c, "k1"); !errors.Is(err, ErrInvalidType) {
+		t.Errorf("mismatched type, expect: %v, got: %v", ErrInvalidType, err)
+		return
+	}
+	if err := c.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	c2, err := New(ctx, 0, 0, persister)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c2.Close()
+
+	if v, err := GetAny[testPoint](c2, "k1"); err != nil {
+		t.Error(err)
+		return
+	} else if v != (testPoint{1, 2}) {
+		t.Errorf("value error. key: k1, expect: {1 2}, got: %v", v)
+		return
+	}
+	if v, err := GetAny[[]testPoint](c2, "k2"); err != nil || len(v) != 1 || v[0] != (testPoint{3, 4}) {
+		t.Errorf("value error. key: k2, expect: [{3 4}], got: %v, %v", v, err)
+		return
+	}
+	if v, err := GetAny[map[string]testPoint](c2, "k3"); err != nil || v["a"] != (testPoint{5, 6}) {
+		t.Errorf("value error. key: k3, expect: map[a:{5 6}], got: %v, %v", v, err)
+		return
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			Update(c, "k1", func(old map[string]int, existed bool) (map[string]int, error) {
+				m := make(map[string]int, len(old)+1)
+				for k, v := range old {
+					m[k] = v
+				}
+				m[fmt.Sprint(i)] = i
+				return m, nil
+			}, NEVER_EXPIRE)
+		}(i)
+	}
+	wg.Wait()
+
+	if v, _ := Get[map[string]int](c, "k1"); len(v) != 100 {
+		t.Errorf("lost updates, expect: 100 entries, got: %v", len(v))
+		return
+	}
+
+	errAbort := errors.New("abort")
+	_, err = Update(c, "k1", func(old map[string]int, existed bool) (map[string]int, error) {
+		return nil, errAbort
+	}, NEVER_EXPIRE)
+	if err != errAbort {
+		t.Errorf("expect: %v, got: %v", errAbort, err)
+		return
+	}
+	if v, _ := Get[map[string]int](c, "k1"); len(v) != 100 {
+		t.Error("value changed by a failed update")
+		return
+	}
+
+	if _, err := Update(c, "k1", func(old string, existed bool) (string, error) {
+		return "x", nil
+	}, NEVER_EXPIRE); !errors.Is(err, ErrInvalidType) {
+		t.Errorf("mismatched type, expect: %v, got: %v", ErrInvalidType, err)
+		return
+	}
+}
+
+func TestSetChecked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errNegative := errors.New("negative value")
+	c, err := New(ctx, 0, 0, nil, WithMaxKeyLen(8), WithValidator(func(key string, val interface{}) error {
+		if v, ok := val.(int); ok && v < 0 {
+			return errNegative
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	if err := SetChecked(c, "k1", 1, NEVER_EXPIRE); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := SetChecked(c, "very-long-key", 1, NEVER_EXPIRE); err != ErrKeyTooLong {
+		t.Errorf("long key, expect: %v, got: %v", ErrKeyTooLong, err)
+		return
+	}
+	if err := SetChecked(c, "k2", -1, NEVER_EXPIRE); err != errNegative {
+		t.Errorf("invalid value, expect: %v, got: %v", errNegative, err)
+		return
+	}
+
+	Set(c, "very-long-key", 1, NEVER_EXPIRE)
+	if err := SetAny(c, "very-long-key", 1, NEVER_EXPIRE); err != ErrKeyTooLong {
+		t.Errorf("long key, expect: %v, got: %v", ErrKeyTooLong, err)
+		return
+	}
+	if n := Len(c); n != 1 {
+		t.Errorf("invalid number of items, expect: 1, got: %v", n)
+		return
+	}
+}
+
+func TestValidateAllWrites(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errNegative := errors.New("negative value")
+	opts := []Option{WithMaxKeyLen(8), WithValidator(func(key string, val interface{}) error {
+		if v, ok := val.(int); ok && v < 0 {
+			return errNegative
+		}
+		return nil
+	})}
+	c, err := New(ctx, 0, 0, nil, opts...)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", 1, NEVER_EXPIRE)
+	_, token, _ := GetCAS[int](c, "k1")
+	if _, err := SetCAS(c, "k1", -1, token, NEVER_EXPIRE); err != errNegative {
+		t.Errorf("SetCAS, expect: %v, got: %v", errNegative, err)
+		return
+	}
+	if _, err := Update(c, "k1", func(old int, existed bool) (int, error) {
+		return -old, nil
+	}, NEVER_EXPIRE); err != errNegative {
+		t.Errorf("Update, expect: %v, got: %v", errNegative, err)
+		return
+	}
+	if err := Rename(c, "k1", "very-long-key"); err != ErrKeyTooLong {
+		t.Errorf("Rename, expect: %v, got: %v", ErrKeyTooLong, err)
+		return
+	}
+	if v, err := Get[int](c, "k1"); err != nil || v != 1 {
+		t.Errorf("rejected writes stored, got: %v, %v", v, err)
+		return
+	}
+
+	if _, err := NewFromMap(ctx, map[string]interface{}{"very-long-key": 1}, NEVER_EXPIRE, opts...); err != ErrKeyTooLong {
+		t.Errorf("NewFromMap, expect: %v, got: %v", ErrKeyTooLong, err)
+		return
+	}
+
+	src, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer src.Close()
+
+	Set(src, "k2", 2, NEVER_EXPIRE)
+	Set(src, "k3", -3, NEVER_EXPIRE)
+	if err := c.CopyFrom(src); err != errNegative {
+		t.Errorf("CopyFrom, expect: %v, got: %v", errNegative, err)
+		return
+	}
+	if n := Len(c); n != 1 {
+		t.Errorf("invalid number of items, expect: 1, got: %v", n)
+		return
+	}
+}
+
+func TestTTLHistogram(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := &fakeClock{now: time.Now()}
+	c, err := NewWithClock(ctx, 0, 0, nil, clock)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", "v1", time.Minute)
+	Set(c, "k2", "v2", time.Minute*5)
+	Set(c, "k3", "v3", time.Minute*10)
+	Set(c, "k4", "v4", time.Hour)
+	Set(c, "k5", "v5", NEVER_EXPIRE)
+	Set(c, "k6", "v6", time.Second)
+	clock.Advance(time.Second * 2)
+
+	if n := ExpiringWithin(c, time.Minute*5); n != 2 {
+		t.Errorf("invalid number of expiring items, expect: 2, got: %v", n)
+		return
+	}
+
+	counts := TTLHistogram(c, []time.Duration{time.Minute, time.Minute * 10})
+	if len(counts) != 3 || counts[0] != 1 || counts[1] != 2 || counts[2] != 1 {
+		t.Errorf("histogram error, expect: [1 2 1], got: %v", counts)
+		return
+	}
+
+	keys := KeysExpiringBetween(c, clock.Now().Add(-time.Hour), clock.Now().Add(time.Minute*5))
+	if len(keys) != 2 || keys[0] != "k1" || keys[1] != "k2" {
+		t.Errorf("invalid expiring keys, expect: [k1 k2], got: %v", keys)
+		return
+	}
+	keys = KeysExpiringBetween(c, clock.Now().Add(time.Minute*5), clock.Now().Add(time.Hour*2))
+	if len(keys) != 2 || keys[0] != "k3" || keys[1] != "k4" {
+		t.Errorf("invalid expiring keys, expect: [k3 k4], got: %v", keys)
+		return
+	}
+}
+
+func TestRandomKeys(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	for i := 0; i < 10; i++ {
+		Set(c, BuildKey("k", i), i, time.Minute)
+	}
+	Set(c, "expired", 0, time.Millisecond)
+	time.Sleep(time.Millisecond * 5)
+
+	keys := RandomKeys(c, 3)
+	if len(keys) != 3 {
+		t.Errorf("invalid number of keys, expect: 3, got: %v", len(keys))
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		if k == "expired" || seen[k] {
+			t.Errorf("invalid sampled key: %v", k)
+			return
+		}
+		seen[k] = true
+	}
+
+	if keys := RandomKeys(c, 100); len(keys) != 10 {
+		t.Errorf("invalid number of keys, expect: 10, got: %v", len(keys))
+		return
+	}
+}
+
+func TestRange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", 1, NEVER_EXPIRE)
+	Set(c, "k2", 2, time.Minute)
+	Set(c, "k3", 3, time.Minute)
+
+	// writes during the iteration are seen by Range, but not by RangeSnapshot
+	sum := 0
+	RangeSnapshot(c, func(key string, val interface{}) bool {
+		Set(c, "k1", 10, NEVER_EXPIRE)
+		Set(c, "k2", 20, time.Minute)
+		Set(c, "k3", 30, time.Minute)
+		sum += val.(int)
+		return true
+	})
+	if sum != 6 {
+		t.Errorf("inconsistent snapshot, expect sum: 6, got: %v", sum)
+		return
+	}
+
+	n := 0
+	Range(c, func(key string, val interface{}) bool {
+		Delete(c, "k2")
+		n++
+		return true
+	})
+	if n != 2 && n != 3 {
+		t.Errorf("invalid number of items, got: %v", n)
+		return
+	}
+
+	n = 0
+	Range(c, func(key string, val interface{}) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Errorf("range not stopped, got %v calls", n)
+		return
+	}
+}
+
+func TestRangeKeyTransform(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// not idempotent, so keys must not be transformed twice
+	transform := func(k string) string { return "h" + strings.ToUpper(k) }
+	c, err := New(ctx, 0, 0, nil, WithKeyTransform(transform))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	ns := c.Namespace("ns")
+	Set(c, "abc", 1, NEVER_EXPIRE)
+	Set(ns, "def", 2, time.Minute)
+
+	for _, cc := range []*Cache{c, ns} {
+		keys := Keys(cc)
+		visited := 0
+		Range(cc, func(key string, val interface{}) bool {
+			visited++
+			return true
+		})
+		if visited != len(keys) {
+			t.Errorf("invalid number of items visited, expect: %v, got: %v", len(keys), visited)
+			return
+		}
+	}
+}
+
+func TestIsPersistent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", 1, NEVER_EXPIRE)
+	Set(c, "k2", 2, time.Minute)
+	Set(c, "k3", 3, time.Millisecond)
+	time.Sleep(time.Millisecond * 5)
+
+	if p, err := IsPersistent(c, "k1"); err != nil || !p {
+		t.Errorf("expect persistent, got: %v, %v", p, err)
+		return
+	}
+	if p, err := IsPersistent(c, "k2"); err != nil || p {
+		t.Errorf("expect volatile, got: %v, %v", p, err)
+		return
+	}
+	if _, err := IsPersistent(c, "k3"); err != ErrNotExists {
+		t.Errorf("expect ErrNotExists for expired key, got: %v", err)
+		return
+	}
+	if _, err := IsPersistent(c, "k4"); err != ErrNotExists {
+		t.Errorf("expect ErrNotExists for missing key, got: %v", err)
+		return
+	}
+
+	if e, err := HasExpiry(c, "k1"); err != nil || e {
+		t.Errorf("expect no expiry, got: %v, %v", e, err)
+		return
+	}
+	if e, err := HasExpiry(c, "k2"); err != nil || !e {
+		t.Errorf("expect expiry, got: %v, %v", e, err)
+		return
+	}
+	if e, err := HasExpiry(c, "k4"); err != ErrNotExists || e {
+		t.Errorf("expect ErrNotExists for missing key, got: %v, %v", e, err)
+		return
+	}
+
+	if ttl, _ := GetTTL(c, "k1"); !IsNeverExpire(ttl) {
+		t.Errorf("expect NEVER_EXPIRE, got: %v", ttl)
+		return
+	}
+	if ttl, _ := GetTTL(c, "k2"); IsNeverExpire(ttl) {
+		t.Errorf("expect a ttl, got: %v", ttl)
+		return
+	}
+}
+
+func TestGetTTLExpired(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := &fakeClock{now: time.Now()}
+	c, err := NewWithClock(ctx, 0, 0, nil, clock)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", 1, NEVER_EXPIRE)
+	Set(c, "k2", 2, time.Second)
+	clock.Advance(time.Second * 2)
+
+	if ttl, err := GetTTL(c, "k1"); err != nil || ttl != NEVER_EXPIRE {
+		t.Errorf("expect NEVER_EXPIRE, got: %v, %v", ttl, err)
+		return
+	}
+	if _, ttl, err := GetWithTTL[int](c, "k1"); err != nil || ttl != NEVER_EXPIRE {
+		t.Errorf("expect NEVER_EXPIRE, got: %v, %v", ttl, err)
+		return
+	}
+
+	if _, err := GetTTL(c, "k2"); err != ErrExpired || !errors.Is(err, ErrNotExists) {
+		t.Errorf("expect ErrExpired, got: %v", err)
+		return
+	}
+	if _, _, err := GetWithTTL[string](c, "k2"); err != ErrExpired {
+		t.Errorf("expect ErrExpired, got: %v", err)
+		return
+	}
+
+	if _, err := GetTTL(c, "k3"); err != ErrNotExists {
+		t.Errorf("expect ErrNotExists, got: %v", err)
+		return
+	}
+	if _, _, err := GetWithTTL[int](c, "k3"); err != ErrNotExists {
+		t.Errorf("expect ErrNotExists, got: %v", err)
+		return
+	}
+}
+
+func TestGetAllOfType(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "s1", "v1", NEVER_EXPIRE)
+	Set(c, "s2", "v2", time.Minute)
+	Set(c, "s3", "v3", time.Millisecond)
+	Set(c, "i1", 1, time.Minute)
+	Set(c, "l1", []int{1, 2}, time.Minute)
+	time.Sleep(time.Millisecond * 5)
+
+	strs := GetAllOfType[string](c)
+	if len(strs) != 2 || strs["s1"] != "v1" || strs["s2"] != "v2" {
+		t.Errorf("invalid strings, got: %v", strs)
+		return
+	}
+
+	lists := GetAllOfType[[]int](c)
+	lists["l1"][0] = 100
+	if v, _ := Get[[]int](c, "l1"); v[0] != 1 {
+		t.Error("slice value not copied")
+		return
+	}
+}
+
+func TestDeleteKeys(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	persister := &FilePersister{FilePath: "persist_delete_keys.bin"}
+	defer os.Remove(persister.FilePath)
+
+	c, err := New(ctx, 0, time.Hour, persister)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", 1, NEVER_EXPIRE)
+	Set(c, "k2", 2, time.Minute)
+	Set(c, "k3", 3, time.Minute)
+	if err := c.Flush(); err != nil {
+		t.Error("flush error:", err)
+		return
+	}
+
+	events, unsubscribe := c.Watch("*")
+	defer unsubscribe()
+
+	if n := DeleteKeys(c, []string{"k1", "k2", "k4"}); n != 2 {
+		t.Errorf("invalid number of deleted keys, expect: 2, got: %v", n)
+		return
+	}
+	for _, key := range []string{"k1", "k2"} {
+		if ev := <-events; ev.Key != key || ev.Type != EventDel {
+			t.Errorf("event error, expect del of %v, got: %v", key, ev)
+			return
+		}
+	}
+
+	if err := c.Flush(); err != nil {
+		t.Error("flush error:", err)
+		return
+	}
+	items, err := persister.Load()
+	if err != nil {
+		t.Error("load error:", err)
+		return
+	}
+	if _, ok := items["k3"]; len(items) != 1 || !ok {
+		t.Errorf("deletion not persisted, got: %v", items)
+		return
+	}
+}
+
+func TestVersion(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	if _, err := SetIfVersion(c, "k1", "v1", 1, time.Minute); err != ErrVersionMismatch {
+		t.Errorf("expect ErrVersionMismatch for a missing key, got: %v", err)
+		return
+	}
+	v1, err := SetIfVersion(c, "k1", "v1", 0, time.Minute)
+	if err != nil {
+		t.Error("create error:", err)
+		return
+	}
+
+	val, version, err := GetWithVersion[string](c, "k1")
+	if err != nil || val != "v1" || version != v1 {
+		t.Errorf("get error, expect: v1 at %v, got: %v at %v, %v", v1, val, version, err)
+		return
+	}
+
+	v2, err := SetIfVersion(c, "k1", "v2", v1, time.Minute)
+	if err != nil || v2 <= v1 {
+		t.Errorf("update error, got version %v after %v, %v", v2, v1, err)
+		return
+	}
+	if _, err := SetIfVersion(c, "k1", "v3", v1, time.Minute); err != ErrVersionMismatch {
+		t.Errorf("expect ErrVersionMismatch for a stale version, got: %v", err)
+		return
+	}
+
+	Set(c, "k1", "v4", time.Minute)
+	if _, version, _ := GetWithVersion[string](c, "k1"); version <= v2 {
+		t.Errorf("version not bumped by Set, got %v after %v", version, v2)
+		return
+	}
+}
+
+func TestSetDefault(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil, WithDefaultTTL(time.Minute))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	SetDefault(c, "k1", "v1")
+	if err := c.SetDefault("k2", 2); err != nil {
+		t.Error("set error:", err)
+		return
+	}
+	for _, key := range []string{"k1", "k2"} {
+		if ttl, err := GetTTL(c, key); err != nil || ttl <= 0 || ttl > time.Minute {
+			t.Errorf("invalid ttl of %v, got: %v, %v", key, ttl, err)
+			return
+		}
+	}
+
+	c2, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c2.Close()
+
+	SetDefault(c2, "k1", "v1")
+	if p, err := IsPersistent(c2, "k1"); err != nil || !p {
+		t.Errorf("expect persistent by default, got: %v, %v", p, err)
+		return
+	}
+}
+
+func TestInvalidTTL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	for _, ttl := range []time.Duration{time.Duration(math.MaxInt64), math.MaxInt64 - 1, time.Hour * 24 * 365 * 200} {
+		if err := SetChecked(c, "k1", 1, ttl); err != nil {
+			t.Errorf("set error with ttl %v: %v", ttl, err)
+			return
+		}
+		if got, err := GetTTL(c, "k1"); err != nil || got <= 0 || got > ttl {
+			t.Errorf("invalid ttl, expect: %v, got: %v, %v", ttl, got, err)
+			return
+		}
+		if p, _ := IsPersistent(c, "k1"); p {
+			t.Errorf("item with ttl %v promoted to persistent", ttl)
+			return
+		}
+	}
+
+	Delete(c, "k1")
+	if err := SetChecked(c, "k1", 1, -time.Second); err != ErrInvalidTTL {
+		t.Errorf("expect ErrInvalidTTL, got: %v", err)
+		return
+	}
+	Set(c, "k1", 1, math.MinInt64)
+	if Exists(c, "k1") {
+		t.Error("write with negative ttl not dropped")
+		return
+	}
+}
+
+func TestScan(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	for i := 0; i < 100; i++ {
+		Set(c, BuildKey("k", i), i, time.Minute)
+	}
+
+	seen := make(map[string]int)
+	var cursor uint64
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Error("scan not done")
+			return
+		}
+
+		var keys []string
+		keys, cursor = Scan(c, cursor, 7)
+		if cursor != 0 && len(keys) != 7 {
+			t.Errorf("invalid page size, expect: 7, got: %v", len(keys))
+			return
+		}
+		for _, key := range keys {
+			seen[key]++
+		}
+
+		// mutate during the scan
+		Set(c, BuildKey("new", pages), pages, time.Minute)
+		Delete(c, BuildKey("new", pages-1))
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		if n := seen[BuildKey("k", i)]; n != 1 {
+			t.Errorf("key %v returned %v times", BuildKey("k", i), n)
+			return
+		}
+	}
+}
+
+func TestSetStrict(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	if err := SetStrict(c, "k1", 1, time.Minute); err != nil {
+		t.Error("set error:", err)
+		return
+	}
+	if err := SetStrict(c, "k1", 2, time.Minute); err != nil {
+		t.Error("set error:", err)
+		return
+	}
+	if err := SetStrict(c, "k1", int64(3), time.Minute); !errors.Is(err, ErrInvalidType) {
+		t.Errorf("expect ErrInvalidType, got: %v", err)
+		return
+	}
+	if v, _ := Get[int](c, "k1"); v != 2 {
+		t.Errorf("value error, expect: 2, got: %v", v)
+		return
+	}
+
+	Set(c, "k2", "v", time.Millisecond)
+	time.Sleep(time.Millisecond * 5)
+	if err := SetStrict(c, "k2", 1, time.Minute); err != nil {
+		t.Error("set over an expired item error:", err)
+		return
+	}
+}
+
+func TestExistsMulti(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", 1, NEVER_EXPIRE)
+	Set(c, "k2", 2, time.Minute)
+	Set(c, "k3", 3, time.Millisecond)
+	time.Sleep(time.Millisecond * 5)
+
+	found := ExistsMulti(c, []string{"k1", "k2", "k3", "k4"})
+	expect := map[string]bool{"k1": true, "k2": true, "k3": false, "k4": false}
+	if fmt.Sprint(found) != fmt.Sprint(expect) {
+		t.Errorf("exists error, expect: %v, got: %v", expect, found)
+		return
+	}
+}
+
+func TestGetSet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	if old, existed, err := GetSet(c, "k1", 1, time.Minute); err != nil || existed || old != 0 {
+		t.Errorf("getset error, got: %v, %v, %v", old, existed, err)
+		return
+	}
+	if old, existed, err := GetSet(c, "k1", 2, time.Minute); err != nil || !existed || old != 1 {
+		t.Errorf("getset error, expect: 1, got: %v, %v, %v", old, existed, err)
+		return
+	}
+	if _, _, err := GetSet(c, "k1", "v", time.Minute); !errors.Is(err, ErrInvalidType) {
+		t.Errorf("expect ErrInvalidType, got: %v", err)
+		return
+	}
+	if v, _ := Get[int](c, "k1"); v != 2 {
+		t.Errorf("value error, expect: 2, got: %v", v)
+		return
+	}
+}
+
+func TestTryGet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", true, time.Minute)
+	if v, ok := TryGet[bool](c, "k1"); !ok || !v {
+		t.Errorf("try get error, got: %v, %v", v, ok)
+		return
+	}
+	if v, ok := TryGet[bool](c, "k2"); ok || v {
+		t.Errorf("try get of a missing key, got: %v, %v", v, ok)
+		return
+	}
+	if v, ok := TryGet[int](c, "k1"); ok || v != 0 {
+		t.Errorf("try get of a mismatching type, got: %v, %v", v, ok)
+		return
+	}
+}
+
+func BenchmarkGetInt64(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		b.Fatal("create cache error:", err)
+	}
+	defer c.Close()
+
+	Set(c, "k", int64(1)<<40, NEVER_EXPIRE)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Get[int64](c, "k")
+	}
+}
+
+func BenchmarkSetInt64(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		b.Fatal("create cache error:", err)
+	}
+	defer c.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Set(c, "k", int64(i)<<20, NEVER_EXPIRE)
+	}
+}
+
+func TestExpireConditional(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := &fakeClock{now: time.UnixMilli(10000)}
+	c, err := NewWithClock(ctx, 0, 0, nil, clock)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "p1", 1, NEVER_EXPIRE)
+	Set(c, "v1", 2, time.Minute)
+
+	cases := []struct {
+		name   string
+		expire func(c *Cache, key string, ttl time.Duration) (bool, error)
+		key    string
+		ttl    time.Duration
+		set    bool
+		expect time.Duration
+	}{
+		{"NX on volatile", ExpireNX, "v1", time.Hour, false, time.Minute},
+		{"GT shorter", ExpireGT, "v1", time.Second, false, time.Minute},
+		{"GT longer", ExpireGT, "v1", time.Hour, true, time.Hour},
+		{"LT longer", ExpireLT, "v1", time.Hour * 2, false, time.Hour},
+		{"LT shorter", ExpireLT, "v1", time.Minute, true, time.Minute},
+		{"GT on persistent", ExpireGT, "p1", time.Hour, false, NEVER_EXPIRE},
+		{"LT on persistent", ExpireLT, "p1", time.Hour, true, time.Hour},
+		{"GT to persistent", ExpireGT, "v1", NEVER_EXPIRE, true, NEVER_EXPIRE},
+		{"NX on persistent", ExpireNX, "v1", time.Second, true, time.Second},
+	}
+	for _, tc := range cases {
+		set, err := tc.expire(c, tc.key, tc.ttl)
+		if err != nil || set != tc.set {
+			t.Errorf("%v: expect set: %v, got: %v, %v", tc.name, tc.set, set, err)
+			return
+		}
+		if ttl, _ := GetTTL(c, tc.key); ttl != tc.expect {
+			t.Errorf("%v: invalid ttl, expect: %v, got: %v", tc.name, tc.expect, ttl)
+			return
+		}
+	}
+
+	if v, _ := Get[int](c, "v1"); v != 2 {
+		t.Errorf("value changed, got: %v", v)
+		return
+	}
+
+	clock.Advance(time.Second * 2)
+	if _, err := ExpireGT(c, "v1", time.Hour); err != ErrNotExists {
+		t.Errorf("expired key, expect: %v, got: %v", ErrNotExists, err)
+		return
+	}
+	if _, err := ExpireLT(c, "p1", -time.Second); err != ErrInvalidTTL {
+		t.Errorf("negative ttl, expect: %v, got: %v", ErrInvalidTTL, err)
+		return
+	}
+}
+
+func TestAppendToSliceConcurrentGet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", make([]int, 0, 1024), NEVER_EXPIRE)
+
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				v, _ := Get[[]int](c, "k1")
+				for j, e := range v {
+					if e != j {
+						t.Errorf("invalid element %v: %v", j, e)
+						return
+					}
+				}
+				// appending to a slice read must not clobber the cache
+				_ = append(v, -1)
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		AppendToSlice(c, "k1", i)
+	}
+	wg.Wait()
+
+	if v, _ := Get[[]int](c, "k1"); len(v) != 200 || v[199] != 199 {
+		t.Errorf("invalid slice, got len: %v", len(v))
+		return
+	}
+}
+
+func TestCompareAndSwapFunc(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", []string{"a", "b"}, NEVER_EXPIRE)
+	equals := func(expect []string) func([]string) bool {
+		return func(current []string) bool {
+			return fmt.Sprint(current) == fmt.Sprint(expect)
+		}
+	}
+
+	if ok, err := CompareAndSwapFunc(c, "k1", equals([]string{"a"}), []string{"c"}, NEVER_EXPIRE); ok || err != nil {
+		t.Errorf("swapped a different value, got: %v, %v", ok, err)
+		return
+	}
+	if ok, err := CompareAndSwapFunc(c, "k1", equals([]string{"a", "b"}), []string{"c"}, NEVER_EXPIRE); !ok || err != nil {
+		t.Errorf("equal value not swapped, got: %v, %v", ok, err)
+		return
+	}
+	if v, _ := Get[[]string](c, "k1"); len(v) != 1 || v[0] != "c" {
+		t.Errorf("invalid value, expect: [c], got: %v", v)
+		return
+	}
+
+	if _, err := CompareAndSwapFunc(c, "k1", func(map[string]int) bool { return true }, nil, NEVER_EXPIRE); !errors.Is(err, ErrInvalidType) {
+		t.Errorf("mismatched type, expect: %v, got: %v", ErrInvalidType, err)
+		return
+	}
+	if _, err := CompareAndSwapFunc(c, "k2", equals(nil), nil, NEVER_EXPIRE); err != ErrNotExists {
+		t.Errorf("missing key, expect: %v, got: %v", ErrNotExists, err)
+		return
+	}
+}
+
+func TestSetManyTTL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil, WithMaxKeyLen(8))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	err = SetManyTTL(c, map[string]Entry[string]{
+		"short": {Val: "v1", TTL: time.Second},
+		"long":  {Val: "v2", TTL: time.Hour},
+		"never": {Val: "v3", TTL: NEVER_EXPIRE},
+	})
+	if err != nil {
+		t.Error("set error:", err)
+		return
+	}
+	if ttl, _ := GetTTL(c, "short"); ttl <= 0 || ttl > time.Second {
+		t.Errorf("invalid ttl of short, got: %v", ttl)
+		return
+	}
+	if ttl, _ := GetTTL(c, "long"); ttl <= time.Second || ttl > time.Hour {
+		t.Errorf("invalid ttl of long, got: %v", ttl)
+		return
+	}
+	if p, _ := IsPersistent(c, "never"); !p {
+		t.Error("never not persistent")
+		return
+	}
+
+	// a TTL of 0 is the DefaultTTL, NEVER_EXPIRE by default
+	if err := SetManyTTL(c, map[string]Entry[string]{"zero": {Val: "v4"}}); err != nil {
+		t.Error("set error:", err)
+		return
+	}
+	if p, err := IsPersistent(c, "zero"); err != nil || !p {
+		t.Errorf("expect the default ttl, got: %v, %v", p, err)
+		return
+	}
+
+	// nothing is stored if an entry is rejected
+	err = SetManyTTL(c, map[string]Entry[string]{
+		"k1":          {Val: "v1", TTL: time.Hour},
+		"toolong_key": {Val: "v2", TTL: time.Hour},
+	})
+	if err != ErrKeyTooLong || Exists(c, "k1") {
+		t.Errorf("expect %v and nothing stored, got: %v, %v", ErrKeyTooLong, err, Keys(c))
+		return
+	}
+}
+
+func TestDeleteMatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "session:1:temp", 1, NEVER_EXPIRE)
+	Set(c, "session:2:temp", 2, time.Minute)
+	Set(c, "session:3:data", 3, NEVER_EXPIRE)
+	Set(c, "other:1:temp", 4, NEVER_EXPIRE)
+
+	if n, err := DeleteMatch(c, "session:*:temp"); err != nil || n != 2 {
+		t.Errorf("invalid number of deleted keys, expect: 2, got: %v, %v", n, err)
+		return
+	}
+	if keys := SortedKeys(c); fmt.Sprint(keys) != "[other:1:temp session:3:data]" {
+		t.Errorf("invalid keys left, got: %v", keys)
+		return
+	}
+
+	if _, err := DeleteMatch(c, "session:["); err == nil {
+		t.Error("expect error for a malformed pattern")
+		return
+	}
+}
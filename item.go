@@ -2,6 +2,7 @@ package gcache
 
 import (
 	"math"
+	"reflect"
 	"time"
 )
 
@@ -9,15 +10,87 @@ const (
 	kNeverExpireMs int64 = math.MaxInt64
 )
 
+// Item is a value as stored and persisted. Scalars are boxed in Object, which
+// costs an allocation per write of a value above 255 but none per read, as
+// Get copies the value out of the box; see BenchmarkGetInt64 and
+// BenchmarkSetInt64. Unboxed storage would save that allocation at the cost
+// of reboxing on every read through interface{}, e.g. GetAny, Range, events
+// and persisting, so Object stays the single representation.
 type Item struct {
 	Object   interface{}
-	ExpireMs int64 // expiration time in ms, never expire if equals to `kNoExpiration`
+	ExpireMs int64  // expiration time in ms, never expire if equals to `kNoExpiration`
+	version  uint64 // in-memory only, changes whenever the item is written, see GetWithVersion
 }
 
 func (item *Item) expired() bool {
-	return time.Now().UnixMilli() > item.ExpireMs
+	return item.expiredAt(time.Now().UnixMilli())
+}
+
+func (item *Item) expiredAt(nowMs int64) bool {
+	return nowMs > item.ExpireMs
 }
 
 func (item *Item) neverExpire() bool {
 	return item.ExpireMs == kNeverExpireMs
 }
+
+// cloneObject copies slice and map objects, which only hold scalars so the
+// copy is deep. Other objects are returned as is.
+func cloneObject(obj interface{}) interface{} {
+	v := reflect.ValueOf(obj)
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.IsNil() {
+			return obj
+		}
+		cp := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(cp, v)
+		return cp.Interface()
+
+	case reflect.Map:
+		if v.IsNil() {
+			return obj
+		}
+		cp := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			cp.SetMapIndex(iter.Key(), iter.Value())
+		}
+		return cp.Interface()
+	}
+
+	return obj
+}
+
+// approxSize estimates the bytes held by obj, ignoring allocator and map
+// overhead.
+func approxSize(obj interface{}) int64 {
+	v := reflect.ValueOf(obj)
+	switch v.Kind() {
+	case reflect.Invalid:
+		return 0
+
+	case reflect.String:
+		return int64(v.Len())
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return int64(v.Len()) * int64(v.Type().Elem().Size())
+		}
+		var size int64
+		for i := 0; i < v.Len(); i++ {
+			size += int64(v.Index(i).Len())
+		}
+		return size
+
+	case reflect.Map:
+		var size int64
+		iter := v.MapRange()
+		for iter.Next() {
+			size += approxSize(iter.Key().Interface()) + approxSize(iter.Value().Interface())
+		}
+		return size
+	}
+
+	return int64(v.Type().Size())
+}
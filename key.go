@@ -0,0 +1,108 @@
+package gcache
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BuildKey deterministically serializes fields into a cache key, so that
+// equal inputs always give equal keys, also across runs. Strings are quoted,
+// maps are rendered in sorted key order, and numbers and bools held by an
+// interface, such as the fields themselves or the elements of an
+// []interface{}, carry their type, e.g. int64(1), so that int(1) and
+// int64(1) give different keys at any depth. Fields must not contain
+// pointers, since their addresses are not stable.
+func BuildKey(fields ...interface{}) string {
+	var b strings.Builder
+	for i, field := range fields {
+		if i > 0 {
+			b.WriteByte(':')
+		}
+		writeKeyValue(&b, reflect.ValueOf(field), true)
+	}
+	return b.String()
+}
+
+// writeKeyValue renders v like %#v, naming the type of scalars if dynamic,
+// i.e. if the type isn't implied by the enclosing value.
+func writeKeyValue(b *strings.Builder, v reflect.Value, dynamic bool) {
+	if !v.IsValid() {
+		b.WriteString("nil")
+		return
+	}
+
+	scalar := func(s string) {
+		if dynamic {
+			fmt.Fprintf(b, "%s(%s)", v.Type(), s)
+		} else {
+			b.WriteString(s)
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		scalar(strconv.FormatBool(v.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		scalar(strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		scalar(strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		scalar(strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()))
+	case reflect.Complex64, reflect.Complex128:
+		scalar(strconv.FormatComplex(v.Complex(), 'g', -1, v.Type().Bits()))
+	case reflect.String:
+		b.WriteString(strconv.Quote(v.String()))
+	case reflect.Interface:
+		writeKeyValue(b, v.Elem(), true)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			fmt.Fprintf(b, "%s(nil)", v.Type())
+			return
+		}
+		fmt.Fprintf(b, "%s{", v.Type())
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeKeyValue(b, v.Index(i), false)
+		}
+		b.WriteByte('}')
+	case reflect.Map:
+		if v.IsNil() {
+			fmt.Fprintf(b, "%s(nil)", v.Type())
+			return
+		}
+		entries := make([]string, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			var e strings.Builder
+			writeKeyValue(&e, iter.Key(), false)
+			e.WriteByte(':')
+			writeKeyValue(&e, iter.Value(), false)
+			entries = append(entries, e.String())
+		}
+		sort.Strings(entries)
+		fmt.Fprintf(b, "%s{%s}", v.Type(), strings.Join(entries, ", "))
+	case reflect.Struct:
+		fmt.Fprintf(b, "%s{", v.Type())
+		for i := 0; i < v.NumField(); i++ {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(v.Type().Field(i).Name)
+			b.WriteByte(':')
+			writeKeyValue(b, v.Field(i), false)
+		}
+		b.WriteByte('}')
+	default:
+		// pointers, channels and functions have no stable rendering
+		if v.CanInterface() {
+			fmt.Fprintf(b, "%#v", v.Interface())
+		} else {
+			fmt.Fprintf(b, "%s(%#x)", v.Type(), v.Pointer())
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package gcache
+
+import "testing"
+
+func TestBuildKey(t *testing.T) {
+	type query struct {
+		Name  string
+		Limit int
+	}
+
+	k1 := BuildKey("user", 1, map[string]int{"a": 1, "b": 2, "c": 3}, query{"x", 10})
+	k2 := BuildKey("user", 1, map[string]int{"c": 3, "b": 2, "a": 1}, query{"x", 10})
+	if k1 != k2 {
+		t.Errorf("identical inputs give different keys: %v, %v", k1, k2)
+		return
+	}
+
+	diffs := []string{
+		BuildKey("user", "1", map[string]int{"a": 1, "b": 2, "c": 3}, query{"x", 10}),
+		BuildKey("user", 1, map[string]int{"a": 1, "b": 2}, query{"x", 10}),
+		BuildKey("user", 1, map[string]int{"a": 1, "b": 2, "c": 3}, query{"x", 11}),
+		BuildKey("user:1", map[string]int{"a": 1, "b": 2, "c": 3}, query{"x", 10}),
+		BuildKey("user", int64(1), map[string]int{"a": 1, "b": 2, "c": 3}, query{"x", 10}),
+		BuildKey("user", 1.0, map[string]int{"a": 1, "b": 2, "c": 3}, query{"x", 10}),
+	}
+	for _, k := range diffs {
+		if k == k1 {
+			t.Errorf("different inputs give the same key: %v", k)
+			return
+		}
+	}
+}
+
+func TestBuildKeyNestedTypes(t *testing.T) {
+	pairs := [][2]string{
+		{BuildKey([]interface{}{1}), BuildKey([]interface{}{int64(1)})},
+		{BuildKey(map[string]interface{}{"a": 1}), BuildKey(map[string]interface{}{"a": 1.0})},
+		{BuildKey(struct{ V interface{} }{uint8(1)}), BuildKey(struct{ V interface{} }{int8(1)})},
+		{BuildKey([]interface{}{true}), BuildKey([]interface{}{"true"})},
+	}
+	for _, pair := range pairs {
+		if pair[0] == pair[1] {
+			t.Errorf("different nested types give the same key: %v", pair[0])
+			return
+		}
+	}
+
+	k1 := BuildKey(map[string]interface{}{"a": []int{1}, "b": nil})
+	k2 := BuildKey(map[string]interface{}{"b": nil, "a": []int{1}})
+	if k1 != k2 {
+		t.Errorf("identical inputs give different keys: %v, %v", k1, k2)
+		return
+	}
+}
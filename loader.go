@@ -0,0 +1,66 @@
+package gcache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Loader loads the value of a missing key from a slower backing store,
+// returning it along with its TTL, see WithLoader. A TTL of 0 stands for the
+// DefaultTTL, so a loader which doesn't care about TTLs doesn't store items
+// already expired.
+type Loader func(key string) (interface{}, time.Duration, error)
+
+type loadCall struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+}
+
+// load calls the loader with extKey and stores the result under key.
+// Concurrent loads of the same key collapse into a single call to the loader.
+func (c *Cache) load(key, extKey string) (interface{}, error) {
+	c.loadMtx.Lock()
+	if call, ok := c.loads[key]; ok {
+		c.loadMtx.Unlock()
+		<-call.done
+		return call.val, call.err
+	}
+	if c.loads == nil {
+		c.loads = make(map[string]*loadCall)
+	}
+	call := &loadCall{done: make(chan struct{})}
+	c.loads[key] = call
+	c.loadMtx.Unlock()
+
+	defer func() {
+		c.loadMtx.Lock()
+		delete(c.loads, key)
+		c.loadMtx.Unlock()
+		close(call.done)
+	}()
+
+	start := time.Now()
+	val, ttl, err := c.opts.loader(extKey)
+	atomic.AddInt64(&c.loaderNanos, int64(time.Since(start)))
+	atomic.AddUint64(&c.loaderCalls, 1)
+	ttl = c.resolveTTL(ttl)
+	if err == nil {
+		err = checkTTL(ttl)
+	}
+	if err == nil {
+		err = c.validate(key, val)
+	}
+	if err != nil {
+		atomic.AddUint64(&c.loaderErrors, 1)
+		call.err = err
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	c.set(key, val, ttl)
+	c.unlock()
+
+	call.val = val
+	return val, nil
+}
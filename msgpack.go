@@ -0,0 +1,570 @@
+package gcache
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+)
+
+// MsgpackCodec encodes the items as MessagePack. It is more compact and
+// faster to decode than GobCodec, without any dependency. Each value is tagged
+// with its Go type so all ValType members round-trip exactly; other types,
+// such as the ones stored by SetAny, are not supported.
+//
+// Layout: a map of key to a 3-element array of expiration ms, type tag and
+// value.
+type MsgpackCodec struct{}
+
+const (
+	kMsgpackSlice byte = 0x20 // type tag flag of slices
+	kMsgpackMap   byte = 0x40 // type tag flag of maps
+)
+
+// msgpackTypes maps type tags to scalar types, 0 is not a valid tag.
+var msgpackTypes = []reflect.Type{
+	nil,
+	reflect.TypeOf(string("")),
+	reflect.TypeOf(bool(false)),
+	reflect.TypeOf(int(0)),
+	reflect.TypeOf(int8(0)),
+	reflect.TypeOf(int16(0)),
+	reflect.TypeOf(int32(0)),
+	reflect.TypeOf(int64(0)),
+	reflect.TypeOf(uint(0)),
+	reflect.TypeOf(uint8(0)),
+	reflect.TypeOf(uint16(0)),
+	reflect.TypeOf(uint32(0)),
+	reflect.TypeOf(uint64(0)),
+	reflect.TypeOf(float32(0)),
+	reflect.TypeOf(float64(0)),
+}
+
+func msgpackScalarTag(t reflect.Type) byte {
+	for tag, st := range msgpackTypes {
+		if st != nil && st == t {
+			return byte(tag)
+		}
+	}
+	return 0
+}
+
+func msgpackTag(t reflect.Type) byte {
+	switch t.Kind() {
+	case reflect.Slice:
+		if tag := msgpackScalarTag(t.Elem()); tag != 0 && t == reflect.SliceOf(t.Elem()) {
+			return tag | kMsgpackSlice
+		}
+	case reflect.Map:
+		if tag := msgpackScalarTag(t.Elem()); tag != 0 && t == reflect.MapOf(msgpackTypes[1], t.Elem()) {
+			return tag | kMsgpackMap
+		}
+	default:
+		return msgpackScalarTag(t)
+	}
+	return 0
+}
+
+func (MsgpackCodec) Marshal(items map[string]Item) ([]byte, error) {
+	b := appendMsgpackMapLen(nil, len(items))
+	for key, item := range items {
+		v := reflect.ValueOf(item.Object)
+		if !v.IsValid() {
+			return nil, invalidTypeError(item.Object)
+		}
+		tag := msgpackTag(v.Type())
+		if tag == 0 {
+			return nil, invalidTypeError(item.Object)
+		}
+
+		b = appendMsgpackString(b, key)
+		b = appendMsgpackArrayLen(b, 3)
+		b = appendMsgpackInt(b, item.ExpireMs)
+		b = appendMsgpackUint(b, uint64(tag))
+
+		switch {
+		case tag&kMsgpackSlice != 0:
+			if v.IsNil() {
+				b = append(b, 0xc0)
+				break
+			}
+			b = appendMsgpackArrayLen(b, v.Len())
+			for i := 0; i < v.Len(); i++ {
+				b = appendMsgpackScalar(b, v.Index(i))
+			}
+
+		case tag&kMsgpackMap != 0:
+			if v.IsNil() {
+				b = append(b, 0xc0)
+				break
+			}
+			b = appendMsgpackMapLen(b, v.Len())
+			iter := v.MapRange()
+			for iter.Next() {
+				b = appendMsgpackString(b, iter.Key().String())
+				b = appendMsgpackScalar(b, iter.Value())
+			}
+
+		default:
+			b = appendMsgpackScalar(b, v)
+		}
+	}
+
+	return b, nil
+}
+
+func (MsgpackCodec) Unmarshal(data []byte) (map[string]Item, error) {
+	d := msgpackDecoder{data: data}
+
+	n, err := d.readMapLen()
+	if err != nil {
+		return nil, err
+	}
+
+	items := make(map[string]Item, n)
+	for i := 0; i < n; i++ {
+		key, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		if l, err := d.readArrayLen(); err != nil {
+			return nil, err
+		} else if l != 3 {
+			return nil, ErrMalformedData
+		}
+		expireMs, err := d.readInt()
+		if err != nil {
+			return nil, err
+		}
+		tag, err := d.readUint()
+		if err != nil {
+			return nil, err
+		}
+		obj, err := d.readObject(tag)
+		if err != nil {
+			return nil, err
+		}
+
+		items[key] = Item{
+			Object:   obj,
+			ExpireMs: expireMs,
+		}
+	}
+
+	return items, nil
+}
+
+func appendMsgpackScalar(b []byte, v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.String:
+		return appendMsgpackString(b, v.String())
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendMsgpackInt(b, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return appendMsgpackUint(b, v.Uint())
+	case reflect.Float32:
+		b = append(b, 0xca)
+		return appendMsgpackUint32(b, math.Float32bits(float32(v.Float())))
+	default: // reflect.Float64
+		b = append(b, 0xcb)
+		return appendMsgpackUint64(b, math.Float64bits(v.Float()))
+	}
+}
+
+func appendMsgpackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= math.MaxInt8:
+		return append(b, byte(i))
+	case i < 0 && i >= -32:
+		return append(b, byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		return append(b, 0xd0, byte(i))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		return appendMsgpackUint16(append(b, 0xd1), uint16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		return appendMsgpackUint32(append(b, 0xd2), uint32(i))
+	default:
+		return appendMsgpackUint64(append(b, 0xd3), uint64(i))
+	}
+}
+
+func appendMsgpackUint(b []byte, u uint64) []byte {
+	switch {
+	case u <= math.MaxInt8:
+		return append(b, byte(u))
+	case u <= math.MaxUint8:
+		return append(b, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		return appendMsgpackUint16(append(b, 0xcd), uint16(u))
+	case u <= math.MaxUint32:
+		return appendMsgpackUint32(append(b, 0xce), uint32(u))
+	default:
+		return appendMsgpackUint64(append(b, 0xcf), u)
+	}
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = appendMsgpackUint16(append(b, 0xda), uint16(n))
+	default:
+		b = appendMsgpackUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendMsgpackArrayLen(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return appendMsgpackUint16(append(b, 0xdc), uint16(n))
+	default:
+		return appendMsgpackUint32(append(b, 0xdd), uint32(n))
+	}
+}
+
+func appendMsgpackMapLen(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return appendMsgpackUint16(append(b, 0xde), uint16(n))
+	default:
+		return appendMsgpackUint32(append(b, 0xdf), uint32(n))
+	}
+}
+
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.data) {
+		return nil, ErrMalformedData
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *msgpackDecoder) readByte() (byte, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// peekNil consumes a nil if it is next.
+func (d *msgpackDecoder) peekNil() bool {
+	if d.pos < len(d.data) && d.data[d.pos] == 0xc0 {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+// readLen reads the big endian length of size bytes following a format byte.
+// Every element takes at least one byte, so lengths beyond the remaining data
+// are rejected before anything gets allocated for them.
+func (d *msgpackDecoder) readLen(size int) (int, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	switch size {
+	case 1:
+		n = int(b[0])
+	case 2:
+		n = int(binary.BigEndian.Uint16(b))
+	default:
+		n = int(binary.BigEndian.Uint32(b))
+	}
+
+	if n > len(d.data)-d.pos {
+		return 0, ErrMalformedData
+	}
+	return n, nil
+}
+
+func (d *msgpackDecoder) readInt() (int64, error) {
+	f, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case f <= 0x7f || f >= 0xe0:
+		return int64(int8(f)), nil
+	case f == 0xd0:
+		b, err := d.next(1)
+		if err != nil {
+			return 0, err
+		}
+		return int64(int8(b[0])), nil
+	case f == 0xd1:
+		b, err := d.next(2)
+		if err != nil {
+			return 0, err
+		}
+		return int64(int16(binary.BigEndian.Uint16(b))), nil
+	case f == 0xd2:
+		b, err := d.next(4)
+		if err != nil {
+			return 0, err
+		}
+		return int64(int32(binary.BigEndian.Uint32(b))), nil
+	case f == 0xd3:
+		b, err := d.next(8)
+		if err != nil {
+			return 0, err
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	}
+	return 0, ErrMalformedData
+}
+
+func (d *msgpackDecoder) readUint() (uint64, error) {
+	f, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case f <= 0x7f:
+		return uint64(f), nil
+	case f >= 0xcc && f <= 0xcf:
+		b, err := d.next(1 << (f - 0xcc))
+		if err != nil {
+			return 0, err
+		}
+		var u uint64
+		for _, c := range b {
+			u = u<<8 | uint64(c)
+		}
+		return u, nil
+	}
+	return 0, ErrMalformedData
+}
+
+func (d *msgpackDecoder) readFloat() (float64, error) {
+	f, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch f {
+	case 0xca:
+		b, err := d.next(4)
+		if err != nil {
+			return 0, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 0xcb:
+		b, err := d.next(8)
+		if err != nil {
+			return 0, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	}
+	return 0, ErrMalformedData
+}
+
+func (d *msgpackDecoder) readBool() (bool, error) {
+	f, err := d.readByte()
+	if err != nil {
+		return false, err
+	}
+
+	switch f {
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	}
+	return false, ErrMalformedData
+}
+
+func (d *msgpackDecoder) readString() (string, error) {
+	f, err := d.readByte()
+	if err != nil {
+		return "", err
+	}
+
+	var n int
+	switch {
+	case f&0xe0 == 0xa0:
+		n = int(f & 0x1f)
+	case f >= 0xd9 && f <= 0xdb:
+		if n, err = d.readLen(1 << (f - 0xd9)); err != nil {
+			return "", err
+		}
+	default:
+		return "", ErrMalformedData
+	}
+
+	b, err := d.next(n)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (d *msgpackDecoder) readArrayLen() (int, error) {
+	f, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case f&0xf0 == 0x90:
+		return int(f & 0x0f), nil
+	case f == 0xdc:
+		return d.readLen(2)
+	case f == 0xdd:
+		return d.readLen(4)
+	}
+	return 0, ErrMalformedData
+}
+
+func (d *msgpackDecoder) readMapLen() (int, error) {
+	f, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case f&0xf0 == 0x80:
+		return int(f & 0x0f), nil
+	case f == 0xde:
+		return d.readLen(2)
+	case f == 0xdf:
+		return d.readLen(4)
+	}
+	return 0, ErrMalformedData
+}
+
+func (d *msgpackDecoder) readObject(tag uint64) (interface{}, error) {
+	scalarTag := tag &^ uint64(kMsgpackSlice|kMsgpackMap)
+	if scalarTag == 0 || scalarTag >= uint64(len(msgpackTypes)) {
+		return nil, ErrMalformedData
+	}
+	t := msgpackTypes[scalarTag]
+
+	switch tag - scalarTag {
+	case 0:
+		v := reflect.New(t).Elem()
+		if err := d.readScalar(v); err != nil {
+			return nil, err
+		}
+		return v.Interface(), nil
+
+	case uint64(kMsgpackSlice):
+		st := reflect.SliceOf(t)
+		if d.peekNil() {
+			return reflect.Zero(st).Interface(), nil
+		}
+		n, err := d.readArrayLen()
+		if err != nil {
+			return nil, err
+		}
+		s := reflect.MakeSlice(st, n, n)
+		for i := 0; i < n; i++ {
+			if err := d.readScalar(s.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return s.Interface(), nil
+
+	case uint64(kMsgpackMap):
+		mt := reflect.MapOf(msgpackTypes[1], t)
+		if d.peekNil() {
+			return reflect.Zero(mt).Interface(), nil
+		}
+		n, err := d.readMapLen()
+		if err != nil {
+			return nil, err
+		}
+		m := reflect.MakeMapWithSize(mt, n)
+		for i := 0; i < n; i++ {
+			k, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			v := reflect.New(t).Elem()
+			if err := d.readScalar(v); err != nil {
+				return nil, err
+			}
+			m.SetMapIndex(reflect.ValueOf(k), v)
+		}
+		return m.Interface(), nil
+	}
+
+	return nil, ErrMalformedData
+}
+
+func (d *msgpackDecoder) readScalar(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.String:
+		s, err := d.readString()
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+
+	case reflect.Bool:
+		b, err := d.readBool()
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := d.readInt()
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := d.readUint()
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+
+	default: // reflect.Float32, reflect.Float64
+		f, err := d.readFloat()
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	}
+
+	return nil
+}
+
+func appendMsgpackUint16(b []byte, u uint16) []byte {
+	return append(b, byte(u>>8), byte(u))
+}
+
+func appendMsgpackUint32(b []byte, u uint32) []byte {
+	return append(b, byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+}
+
+func appendMsgpackUint64(b []byte, u uint64) []byte {
+	return appendMsgpackUint32(appendMsgpackUint32(b, uint32(u>>32)), uint32(u))
+}
@@ -0,0 +1,64 @@
+package gcache
+
+import "strings"
+
+// Namespace returns a view of the cache whose keys are transparently prefixed
+// by name and ':', e.g. to separate tenants while persisting them together.
+// Namespaces nest. Keys, Scan, Range, Len and the like only cover the keys of
+// the namespace, Clear only deletes those, and Watch only reports those, all
+// unprefixed. Everything else, e.g. Close, Flush, Stats and the options, is
+// shared with the whole cache, and Loader, Writer and Validator see the
+// prefixed keys. A KeyTransform applies to the key within the namespace.
+func (c *Cache) Namespace(name string) *Cache {
+	return &Cache{core: c.core, prefix: c.prefix + name + ":"}
+}
+
+// key maps a key of the caller to the key stored.
+func (c *Cache) key(k string) string {
+	if c.opts.keyTransform != nil {
+		k = c.opts.keyTransform(k)
+	}
+	if c.prefix == "" {
+		return k
+	}
+	return c.prefix + k
+}
+
+// extKey maps a key of the caller to the key seen by the Loader and the
+// Writer, which is namespaced but not transformed.
+func (c *Cache) extKey(k string) string {
+	if c.prefix == "" {
+		return k
+	}
+	return c.prefix + k
+}
+
+// ownKey maps a stored key back to the key of the caller, if it belongs to
+// the namespace.
+func (c *Cache) ownKey(k string) (string, bool) {
+	if !strings.HasPrefix(k, c.prefix) {
+		return "", false
+	}
+	return k[len(c.prefix):], true
+}
+
+// clearNamespace removes the items of the namespace. Must be called with
+// c.mtx held.
+func (c *Cache) clearNamespace() {
+	for key, item := range c.persistItems {
+		if _, own := c.ownKey(key); own {
+			delete(c.persistItems, key)
+			c.changes++
+			c.record(key, EventDel, item)
+		}
+	}
+	for key, item := range c.volatileItems {
+		if _, own := c.ownKey(key); own {
+			delete(c.volatileItems, key)
+			c.stopTimer(key)
+			c.changes++
+			c.record(key, EventDel, item)
+		}
+	}
+	c.notifyEmpty()
+}
@@ -0,0 +1,125 @@
+package gcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestNamespace(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	persister := &FilePersister{FilePath: "persist_namespace.bin"}
+	defer os.Remove(persister.FilePath)
+
+	c, err := New(ctx, 0, time.Hour, persister)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+
+	t1 := c.Namespace("t1")
+	t2 := c.Namespace("t2")
+
+	events, unsubscribe := t1.Watch("*")
+	defer unsubscribe()
+
+	Set(t1, "k1", "a", NEVER_EXPIRE)
+	Set(t1, "k2", "b", time.Minute)
+	Set(t2, "k1", "c", NEVER_EXPIRE)
+
+	if v, _ := Get[string](t1, "k1"); v != "a" {
+		t.Errorf("value error, expect: a, got: %v", v)
+		return
+	}
+	if v, _ := Get[string](c, "t2:k1"); v != "c" {
+		t.Errorf("value error, expect: c, got: %v", v)
+		return
+	}
+
+	keys := Keys(t1)
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "k1" || keys[1] != "k2" || Len(t1) != 2 || Len(c) != 3 {
+		t.Errorf("invalid keys, got: %v", keys)
+		return
+	}
+
+	if ev := <-events; ev.Key != "k1" || ev.Type != EventSet {
+		t.Errorf("event error, got: %v", ev)
+		return
+	}
+
+	t1.Clear()
+	if Len(t1) != 0 || !Exists(t2, "k1") {
+		t.Error("clear not limited to the namespace")
+		return
+	}
+
+	// namespaces persist together
+	Set(t1, "k3", "d", NEVER_EXPIRE)
+	if err := c.Close(); err != nil {
+		t.Error("close error:", err)
+		return
+	}
+	c2, err := New(ctx, 0, time.Hour, persister)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c2.Close()
+
+	if !Exists(c2.Namespace("t1"), "k3") || !Exists(c2.Namespace("t2"), "k1") {
+		t.Errorf("namespaces not persisted, got: %v", Keys(c2))
+		return
+	}
+}
+
+func TestKeyTransform(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hash := func(key string) string {
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(key)))
+	}
+	var loaded string
+	loader := func(key string) (interface{}, time.Duration, error) {
+		loaded = key
+		return "loaded", time.Minute, nil
+	}
+
+	c, err := New(ctx, 0, 0, nil, WithKeyTransform(hash), WithLoader(loader))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	url := "https://example.com/a/very/long/path?with=query"
+	Set(c, url, "v1", time.Minute)
+	if v, err := Get[string](c, url); err != nil || v != "v1" {
+		t.Errorf("get error, expect: v1, got: %v, %v", v, err)
+		return
+	}
+	if keys := Keys(c); len(keys) != 1 || keys[0] != hash(url) {
+		t.Errorf("key not transformed, got: %v", keys)
+		return
+	}
+
+	Delete(c, url)
+	if v, _ := Get[string](c, url); v != "loaded" || loaded != url {
+		t.Errorf("loader not called with the original key, got: %v", loaded)
+		return
+	}
+
+	ns := c.Namespace("t1")
+	Set(ns, url, "v2", time.Minute)
+	if keys := Keys(ns); len(keys) != 1 || keys[0] != hash(url) {
+		t.Errorf("key not transformed within the namespace, got: %v", keys)
+		return
+	}
+}
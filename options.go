@@ -0,0 +1,301 @@
+package gcache
+
+import "time"
+
+type options struct {
+	cleanupInterval     time.Duration
+	persistInterval     time.Duration
+	persister           Persister
+	expiryTimers        bool
+	minPersistTTL       time.Duration
+	copyOnSet           bool
+	minChangesToPersist int
+	typeCheck           bool
+	onPersistError      func(error)
+	clock               Clock
+	jitter              float64
+	maxKeyLen           int
+	validator           func(key string, val interface{}) error
+	loader              Loader
+	writer              Writer
+	writeBehind         bool
+	defaultTTL          time.Duration
+	maxItems            int
+	evictionPolicy      EvictionPolicy
+	cleanupBatchSize    int
+	memoryPressure      func() bool
+	trackRecency        bool
+	shedFraction        float64
+	initialCapacity     int
+	keyTransform        func(key string) string
+	maxPendingBytes     int64
+	expiryPrecision     time.Duration
+	compactInterval     time.Duration
+	failOpen            bool
+	onExpired           func(key string, val interface{})
+}
+
+// Option configures a Cache at construction.
+type Option func(*options)
+
+func WithCleanupInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.cleanupInterval = d
+	}
+}
+
+func WithPersistInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.persistInterval = d
+	}
+}
+
+// WithPersister makes the cache load its items from p on New and save them to
+// p every persist interval and on Close. A LogPersister, e.g. LogFilePersister,
+// also logs every single write with the cache locked, which makes writes
+// slower, see LogFilePersister.
+func WithPersister(p Persister) Option {
+	return func(o *options) {
+		o.persister = p
+	}
+}
+
+// WithExpiryTimers removes each volatile item by its own timer right at its
+// expiration, instead of waiting for the next cleanup. It costs one timer per
+// volatile item.
+func WithExpiryTimers() Option {
+	return func(o *options) {
+		o.expiryTimers = true
+	}
+}
+
+// WithMinPersistTTL skips volatile items whose remaining TTL is below d when
+// persisting, such items won't survive a restart.
+func WithMinPersistTTL(d time.Duration) Option {
+	return func(o *options) {
+		o.minPersistTTL = d
+	}
+}
+
+// WithCopyOnSet makes writes store a copy of slice and map values, so later
+// changes by the caller can't corrupt the cache. It costs one allocation and
+// copy per slice or map write; scalar writes are unaffected.
+func WithCopyOnSet() Option {
+	return func(o *options) {
+		o.copyOnSet = true
+	}
+}
+
+// WithMinChangesToPersist skips the periodic persist until at least n changes
+// accumulated, to reduce disk churn. Close and Flush persist regardless, but
+// fewer changes may be lost on a crash.
+func WithMinChangesToPersist(n int) Option {
+	return func(o *options) {
+		o.minChangesToPersist = n
+	}
+}
+
+// WithTypeCheck makes SetAny and NewFromMap reject values whose type isn't
+// registered for persistence, see RegisterType, instead of failing later when
+// persisting.
+func WithTypeCheck() Option {
+	return func(o *options) {
+		o.typeCheck = true
+	}
+}
+
+// WithOnPersistError calls fn whenever persisting fails. Failed persists are
+// retried on the next persist tick either way. With a LogPersister, fn is also
+// called when appending writes to the log fails, once per cache call.
+func WithOnPersistError(fn func(error)) Option {
+	return func(o *options) {
+		o.onPersistError = fn
+	}
+}
+
+// WithClock makes the cache tell time by clock instead of the system clock.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithJitter randomizes every cleanup and persist interval by ±jitter, e.g.
+// 0.1 for ±10%, so that many instances started at once don't hit shared
+// storage at the same time. 0 disables it.
+func WithJitter(jitter float64) Option {
+	return func(o *options) {
+		o.jitter = jitter
+	}
+}
+
+// WithMaxKeyLen rejects writes of keys longer than n bytes with ErrKeyTooLong.
+// Set and SetAt drop such writes, SetChecked and SetAny return the error.
+func WithMaxKeyLen(n int) Option {
+	return func(o *options) {
+		o.maxKeyLen = n
+	}
+}
+
+// WithValidator rejects writes for which fn returns an error. Set and SetAt
+// drop such writes, SetChecked and SetAny return the error.
+func WithValidator(fn func(key string, val interface{}) error) Option {
+	return func(o *options) {
+		o.validator = fn
+	}
+}
+
+// WithLoader makes Get and GetAny call fn on a miss, store the loaded value
+// with the returned TTL, the DefaultTTL if 0, and return it, so the cache can
+// front a slower store without changing the call sites. Concurrent misses of
+// a key share a single call to fn. Errors of fn are returned as is and
+// nothing is stored.
+func WithLoader(fn Loader) Option {
+	return func(o *options) {
+		o.loader = fn
+	}
+}
+
+// WithWriter makes Set, SetChecked, SetAny, SetManyTTL and the unconditional
+// SetWith hand every write over to fn, with the ttl as passed, so the cache
+// can front a durable store. Other writes, e.g. Increase or Update, say that
+// they skip the Writer. By default fn is called synchronously before the
+// value is stored (write-through); if it fails the value isn't stored, and
+// SetChecked and SetAny return the error.
+// Writes of the same key are handed over in the order they are stored, so fn
+// must not write to the cache.
+func WithWriter(fn Writer) Option {
+	return func(o *options) {
+		o.writer = fn
+	}
+}
+
+// WithWriteBehind buffers the writes for the Writer instead, and hands them
+// over on the persist tick, on Flush and on Close, keeping only the last
+// write per key. Failed writes are reported to OnPersistError and retried on
+// the next flush.
+func WithWriteBehind() Option {
+	return func(o *options) {
+		o.writeBehind = true
+	}
+}
+
+// WithDefaultTTL sets the TTL of SetDefault, SetWith, and of the Loader and
+// SetManyTTL entries returning a TTL of 0, NEVER_EXPIRE by default.
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.defaultTTL = ttl
+	}
+}
+
+// WithMaxItems bounds the cache to n items, evicting items by the
+// EvictionPolicy whenever a write exceeds it. Evictions fire EventEvicted.
+func WithMaxItems(n int) Option {
+	return func(o *options) {
+		o.maxItems = n
+	}
+}
+
+// WithEvictionPolicy sets the policy of MaxItems, VolatileTTL by default.
+func WithEvictionPolicy(p EvictionPolicy) Option {
+	return func(o *options) {
+		o.evictionPolicy = p
+	}
+}
+
+// WithCleanupBatchSize makes the cleanup release the lock after every n
+// expired items, so it never stalls readers and writers for long on a large
+// cache. Waiting goroutines get in between batches, so a full cleanup takes
+// slightly longer. 0, the default, removes all expired items at once.
+func WithCleanupBatchSize(n int) Option {
+	return func(o *options) {
+		o.cleanupBatchSize = n
+	}
+}
+
+// WithMemoryPressure calls check after every cleanup, and sheds the given
+// fraction of the volatile items, least recently used first, whenever it
+// returns true, see Shed and HeapAbove. Callers running their own memory
+// monitor may pass a nil check and call Shed instead. The cache then tracks
+// which volatile items Get and GetAny read and the writes set, at the cost of
+// a list entry per volatile item and a short lock per read.
+func WithMemoryPressure(check func() bool, fraction float64) Option {
+	return func(o *options) {
+		o.memoryPressure = check
+		o.shedFraction = fraction
+		o.trackRecency = true
+	}
+}
+
+// WithInitialCapacity allocates room for n persistent and n volatile items up
+// front, to avoid growing the maps during a large warm-up. Maps are sized by
+// the persisted items on load regardless.
+func WithInitialCapacity(n int) Option {
+	return func(o *options) {
+		o.initialCapacity = n
+	}
+}
+
+// WithKeyTransform stores every key as fn(key), e.g. a hash of long keys to
+// save memory. All functions taking keys transform them, so callers keep
+// using the original keys, but Keys, Scan, Range, Watch and the like deal
+// with the transformed keys, which can't be mapped back. Loader and Writer
+// get the original keys, MaxKeyLen and Validator the transformed ones.
+func WithKeyTransform(fn func(key string) string) Option {
+	return func(o *options) {
+		o.keyTransform = fn
+	}
+}
+
+// WithMaxPendingBytes bounds the writes buffered by WriteBehind to about n
+// bytes, see PendingPersistBytes. Once full, writes are rejected with
+// ErrPersistBacklog until a flush catches up, to push back on producers when
+// the Writer can't keep up. Set drops such writes like other rejected writes.
+// A single write larger than n is accepted once nothing else is pending.
+func WithMaxPendingBytes(n int64) Option {
+	return func(o *options) {
+		o.maxPendingBytes = n
+	}
+}
+
+// WithExpiryPrecision rounds expirations up to a multiple of d, e.g. a
+// second, so items written close together expire together, which makes the
+// cleanup and the expiry timers batch up. Items live up to d longer than
+// asked. Expirations are precise to the millisecond by default.
+func WithExpiryPrecision(d time.Duration) Option {
+	return func(o *options) {
+		o.expiryPrecision = d
+	}
+}
+
+// WithCompactInterval runs Compact after the cleanup once at least d passed
+// since the last compaction, so a cache with bursty load returns the memory
+// of the items gone. Compactions happen at the cleanup intervals, so d is
+// best a multiple of the cleanup interval. 0, the default, disables it.
+func WithCompactInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.compactInterval = d
+	}
+}
+
+// WithFailOpen starts the cache empty if the persister fails to load,
+// instead of failing New, as a cold cache beats a service which can't start.
+// The error is reported to OnPersistError and LastPersistError. The first
+// persist after a write overwrites what couldn't be loaded.
+func WithFailOpen() Option {
+	return func(o *options) {
+		o.failOpen = true
+	}
+}
+
+// WithOnExpired calls fn with the key, as stored, and the value of every item
+// removed after its expiration, exactly once per item whichever way it went:
+// by the cleanup, by its expiry timer, or by a delete or an overwrite before
+// the cleanup got to it. fn is called by the function which removed the item,
+// right after it released the lock, so it may call back into the cache. A
+// panic in fn is recovered.
+func WithOnExpired(fn func(key string, val interface{})) Option {
+	return func(o *options) {
+		o.onExpired = fn
+	}
+}
@@ -1,9 +1,16 @@
 package gcache
 
 import (
+	"bufio"
+	"encoding/binary"
 	"encoding/gob"
 	"errors"
+	"fmt"
+	"io"
 	"os"
+	"reflect"
+	"sync"
+	"time"
 )
 
 type Persister interface {
@@ -11,63 +18,143 @@ type Persister interface {
 	Save(items map[string]Item) error
 }
 
+// StreamLoader is a Persister which can hand the items over one by one as it
+// decodes them, so New inserts them right away instead of holding them all
+// twice in memory while loading. New prefers LoadEach over Load.
+type StreamLoader interface {
+	Persister
+
+	// LoadEach calls size with the number of never expiring and of volatile
+	// items, at most, if it knows them, so the cache can allocate its maps
+	// once, then fn for each unexpired item. On error, the items handed over
+	// so far are dropped.
+	LoadEach(size func(persistent, volatile int), fn func(key string, item Item)) error
+}
+
+// PartialPersister is a Persister which can save only the items changed and
+// the keys deleted since the last successful save, e.g. to push small diffs
+// to a remote store. The cache falls back to Save for other persisters.
+type PartialPersister interface {
+	Persister
+	SavePartial(changed map[string]Item, deleted []string) error
+}
+
+// FilePersister saves the items to FilePath, as a header of kFormatMagic and
+// the format version followed by the item counts and the Codec-encoded items,
+// see encodeSnapshot. LoadEach hands the counts over first, so New sizes the
+// maps once.
 type FilePersister struct {
 	FilePath string
+	Codec    Codec // GobCodec if nil
+
+	// Migrate, if not nil, decodes the payload of files in an older format
+	// version, e.g. after changing the Codec. Files written before the header
+	// was introduced are version 0. Older payloads are decoded by the Codec
+	// otherwise.
+	Migrate func(version uint8, payload []byte) (map[string]Item, error)
+
+	// OnLoadError, if not nil, is called for each item Load skips as it can't
+	// be decoded, e.g. as its type is no longer registered. Load fails only if
+	// the file itself is malformed.
+	OnLoadError func(key string, err error)
 }
 
+const (
+	kFormatMagic   = "GCACHE"
+	kFormatVersion = 2 // see encodeSnapshot, v1 is the Codec-encoded map of items
+)
+
 func init() {
 	// Scalar types
-	gob.Register(string(""))
-	gob.Register(bool(false))
-	gob.Register(int(0))
-	gob.Register(uint(0))
-	gob.Register(int8(0))
-	gob.Register(uint8(0))
-	gob.Register(int16(0))
-	gob.Register(uint16(0))
-	gob.Register(int32(0))
-	gob.Register(uint32(0))
-	gob.Register(int64(0))
-	gob.Register(uint64(0))
-	gob.Register(float32(0))
-	gob.Register(float64(0))
+	RegisterType(string(""))
+	RegisterType(bool(false))
+	RegisterType(int(0))
+	RegisterType(uint(0))
+	RegisterType(int8(0))
+	RegisterType(uint8(0))
+	RegisterType(int16(0))
+	RegisterType(uint16(0))
+	RegisterType(int32(0))
+	RegisterType(uint32(0))
+	RegisterType(int64(0))
+	RegisterType(uint64(0))
+	RegisterType(float32(0))
+	RegisterType(float64(0))
 
 	// Slice types
-	gob.Register([]string{})
-	gob.Register([]bool{})
-	gob.Register([]int{})
-	gob.Register([]uint{})
-	gob.Register([]int8{})
-	gob.Register([]uint8{})
-	gob.Register([]int16{})
-	gob.Register([]uint16{})
-	gob.Register([]int32{})
-	gob.Register([]uint32{})
-	gob.Register([]int64{})
-	gob.Register([]uint64{})
-	gob.Register([]float32{})
-	gob.Register([]float64{})
+	RegisterType([]string{})
+	RegisterType([]bool{})
+	RegisterType([]int{})
+	RegisterType([]uint{})
+	RegisterType([]int8{})
+	RegisterType([]uint8{})
+	RegisterType([]int16{})
+	RegisterType([]uint16{})
+	RegisterType([]int32{})
+	RegisterType([]uint32{})
+	RegisterType([]int64{})
+	RegisterType([]uint64{})
+	RegisterType([]float32{})
+	RegisterType([]float64{})
 
 	// Map types
-	gob.Register(map[string]string{})
-	gob.Register(map[string]bool{})
-	gob.Register(map[string]int{})
-	gob.Register(map[string]uint{})
-	gob.Register(map[string]int8{})
-	gob.Register(map[string]uint8{})
-	gob.Register(map[string]int16{})
-	gob.Register(map[string]uint16{})
-	gob.Register(map[string]int32{})
-	gob.Register(map[string]uint32{})
-	gob.Register(map[string]int64{})
-	gob.Register(map[string]uint64{})
-	gob.Register(map[string]float32{})
-	gob.Register(map[string]float64{})
+	RegisterType(map[string]string{})
+	RegisterType(map[string]bool{})
+	RegisterType(map[string]int{})
+	RegisterType(map[string]uint{})
+	RegisterType(map[string]int8{})
+	RegisterType(map[string]uint8{})
+	RegisterType(map[string]int16{})
+	RegisterType(map[string]uint16{})
+	RegisterType(map[string]int32{})
+	RegisterType(map[string]uint32{})
+	RegisterType(map[string]int64{})
+	RegisterType(map[string]uint64{})
+	RegisterType(map[string]float32{})
+	RegisterType(map[string]float64{})
+
+}
+
+var (
+	registeredMtx   sync.RWMutex
+	registeredTypes = make(map[reflect.Type]struct{})
+)
+
+// RegisterType registers the type of sample for persistence, needed for the
+// custom types stored by SetAny. Call it before New.
+func RegisterType(sample interface{}) {
+	gob.Register(sample)
 
+	registeredMtx.Lock()
+	registeredTypes[reflect.TypeOf(sample)] = struct{}{}
+	registeredMtx.Unlock()
+}
+
+// RegisterForPersistence registers the types of samples like RegisterType,
+// e.g. RegisterForPersistence(point{}, []point{}, map[string]point{}). Only
+// the types of the values stored need to be registered, not those nested in
+// them. GobCodec and TypeCheck honor the registered types, MsgpackCodec only
+// supports ValType values regardless. Call it before New.
+func RegisterForPersistence(samples ...interface{}) {
+	for _, sample := range samples {
+		RegisterType(sample)
+	}
+}
+
+// checkRegistered returns an error if the type of obj isn't registered.
+func checkRegistered(obj interface{}) error {
+	registeredMtx.RLock()
+	_, ok := registeredTypes[reflect.TypeOf(obj)]
+	registeredMtx.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %T not registered for persistence", ErrInvalidType, obj)
+	}
+	return nil
 }
 
 func (p *FilePersister) Load() (map[string]Item, error) {
-	r, err := os.Open(p.FilePath)
+	data, err := os.ReadFile(p.FilePath)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			if w, err := os.Create(p.FilePath); err != nil {
@@ -79,11 +166,15 @@ func (p *FilePersister) Load() (map[string]Item, error) {
 		}
 		return nil, err
 	}
-	defer r.Close()
 
-	dec := gob.NewDecoder(r)
-	items := make(map[string]Item)
-	dec.Decode(&items)
+	// a new file is empty, but a corrupt one must not load as an empty cache
+	if len(data) == 0 {
+		return make(map[string]Item), nil
+	}
+	items, err := decodeSnapshot(data, p.codec(), p.Migrate, p.OnLoadError)
+	if err != nil {
+		return nil, err
+	}
 
 	for key, item := range items {
 		if item.expired() {
@@ -94,12 +185,284 @@ func (p *FilePersister) Load() (map[string]Item, error) {
 	return items, nil
 }
 
+// LoadEach streams the items of the file to fn as they are decoded, see
+// StreamLoader. Files in an older format are loaded whole.
+func (p *FilePersister) LoadEach(size func(persistent, volatile int), fn func(key string, item Item)) error {
+	f, err := os.Open(p.FilePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return p.loadEachWhole(size, fn)
+		}
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(f)
+	header, err := r.Peek(len(kFormatMagic) + 1)
+	if err != nil || string(header[:len(kFormatMagic)]) != kFormatMagic || header[len(kFormatMagic)] != kFormatVersion {
+		// empty, legacy or unsupported
+		return p.loadEachWhole(size, fn)
+	}
+	r.Discard(len(header))
+
+	// bound the counts and field lengths by the file size, so a corrupt one
+	// can't allocate a huge buffer
+	maxLen := uint64(fi.Size())
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > maxLen {
+		return ErrMalformedData
+	}
+	persistent, err := binary.ReadUvarint(r)
+	if err != nil || persistent > n {
+		return ErrMalformedData
+	}
+	size(int(persistent), int(n-persistent))
+
+	decode := newRecordDecoder(p.codec())
+	nowMs := time.Now().UnixMilli()
+	for records := uint64(0); ; records++ {
+		key, err := readStreamField(r, maxLen)
+		if err == io.EOF {
+			// a file cut off between two records lacks some
+			if records != n {
+				return ErrMalformedData
+			}
+			return nil
+		}
+		if err != nil {
+			return ErrMalformedData
+		}
+		encoded, err := readStreamField(r, maxLen)
+		if err != nil {
+			return ErrMalformedData
+		}
+
+		item, err := decode(string(key), encoded)
+		if err != nil {
+			if p.OnLoadError != nil {
+				p.OnLoadError(string(key), err)
+			}
+			continue
+		}
+		if !item.expiredAt(nowMs) {
+			fn(string(key), item)
+		}
+	}
+}
+
+func (p *FilePersister) loadEachWhole(size func(persistent, volatile int), fn func(key string, item Item)) error {
+	items, err := p.Load()
+	if err != nil {
+		return err
+	}
+	persistent := countPersistent(items)
+	size(persistent, len(items)-persistent)
+	for key, item := range items {
+		fn(key, item)
+	}
+	return nil
+}
+
+// readStreamField reads a field preceded by its uvarint length off r. It
+// returns io.EOF only if r is exhausted before the field.
+func readStreamField(r *bufio.Reader, maxLen uint64) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxLen {
+		return nil, ErrMalformedData
+	}
+	field := make([]byte, n)
+	if _, err := io.ReadFull(r, field); err != nil {
+		return nil, ErrMalformedData
+	}
+	return field, nil
+}
+
 func (p *FilePersister) Save(items map[string]Item) error {
-	w, err := os.Create(p.FilePath)
+	data, err := encodeSnapshot(items, p.codec())
 	if err != nil {
 		return err
 	}
-	defer w.Close()
 
-	return gob.NewEncoder(w).Encode(&items)
+	return os.WriteFile(p.FilePath, data, 0666)
+}
+
+func (p *FilePersister) codec() Codec {
+	if p.Codec == nil {
+		return GobCodec{}
+	}
+	return p.Codec
+}
+
+// encodeSnapshot encodes items with the format header. The v2 payload is the
+// uvarint number of items and of never expiring items, so the cache can size
+// its maps before loading, followed by a record per item, so that an item
+// which can't be decoded doesn't fail the others: the key and the encoded
+// item, each preceded by its uvarint length. GobCodec encodes the items of a
+// file as one stream, so the type information is written once, other Codecs
+// encode each item as a one-item map.
+func encodeSnapshot(items map[string]Item, codec Codec) ([]byte, error) {
+	var lenBuf [binary.MaxVarintLen64]byte
+	appendField := func(data, field []byte) []byte {
+		n := binary.PutUvarint(lenBuf[:], uint64(len(field)))
+		return append(append(data, lenBuf[:n]...), field...)
+	}
+
+	data := append([]byte(kFormatMagic), kFormatVersion)
+	n := binary.PutUvarint(lenBuf[:], uint64(len(items)))
+	data = append(data, lenBuf[:n]...)
+	n = binary.PutUvarint(lenBuf[:], uint64(countPersistent(items)))
+	data = append(data, lenBuf[:n]...)
+	encode := newRecordEncoder(codec)
+	for key, item := range items {
+		payload, err := encode(key, item)
+		if err != nil {
+			return nil, err
+		}
+		data = appendField(data, []byte(key))
+		data = appendField(data, payload)
+	}
+
+	return data, nil
+}
+
+// countPersistent returns the number of never expiring items.
+func countPersistent(items map[string]Item) int {
+	n := 0
+	for _, item := range items {
+		if item.neverExpire() {
+			n++
+		}
+	}
+	return n
+}
+
+// decodeSnapshot decodes data written by encodeSnapshot, or by an older
+// version through migrate. Data without header is version 0. Items which
+// can't be decoded are skipped and reported to onLoadError.
+func decodeSnapshot(data []byte, codec Codec, migrate func(uint8, []byte) (map[string]Item, error),
+	onLoadError func(key string, err error)) (map[string]Item, error) {
+	var version uint8
+	payload := data
+	if len(data) > len(kFormatMagic) && string(data[:len(kFormatMagic)]) == kFormatMagic {
+		version = data[len(kFormatMagic)]
+		payload = data[len(kFormatMagic)+1:]
+	}
+
+	if version > kFormatVersion {
+		return nil, fmt.Errorf("%w: format version %d", ErrUnsupportedFormat, version)
+	}
+	if version < kFormatVersion {
+		if migrate != nil {
+			return migrate(version, payload)
+		}
+		// v0 and v1 are the Codec-encoded map of items
+		return codec.Unmarshal(payload)
+	}
+
+	return decodeRecords(payload, newRecordDecoder(codec), onLoadError)
+}
+
+// decodeRecords decodes the counts and the records of a v2 payload, the items
+// of the records with decode.
+func decodeRecords(payload []byte, decode recordDecoder, onLoadError func(key string, err error)) (map[string]Item, error) {
+	n, l := binary.Uvarint(payload)
+	if l <= 0 || n > uint64(len(payload)) {
+		return nil, ErrMalformedData
+	}
+	payload = payload[l:]
+	persistent, l := binary.Uvarint(payload)
+	if l <= 0 || persistent > n {
+		return nil, ErrMalformedData
+	}
+	payload = payload[l:]
+
+	items := make(map[string]Item, n)
+	var records uint64
+	for ; len(payload) > 0; records++ {
+		key, rest, ok := readField(payload)
+		if !ok {
+			return nil, ErrMalformedData
+		}
+		encoded, rest, ok := readField(rest)
+		if !ok {
+			return nil, ErrMalformedData
+		}
+		payload = rest
+
+		if item, err := decode(string(key), encoded); err == nil {
+			items[string(key)] = item
+		} else if onLoadError != nil {
+			onLoadError(string(key), err)
+		}
+	}
+	// a file cut off between two records lacks some
+	if records != n {
+		return nil, ErrMalformedData
+	}
+
+	return items, nil
+}
+
+type (
+	// recordEncoder encodes the item of key for a record, the result is valid
+	// until the next call.
+	recordEncoder func(key string, item Item) ([]byte, error)
+	// recordDecoder decodes the item of key from a record. Records must be
+	// decoded in the order they were encoded.
+	recordDecoder func(key string, encoded []byte) (Item, error)
+)
+
+// recordCodec is implemented by Codecs which encode the records of a file as
+// one stream.
+type recordCodec interface {
+	newRecordEncoder() recordEncoder
+	newRecordDecoder() recordDecoder
+}
+
+func newRecordEncoder(codec Codec) recordEncoder {
+	if rc, ok := codec.(recordCodec); ok {
+		return rc.newRecordEncoder()
+	}
+	return func(key string, item Item) ([]byte, error) {
+		return codec.Marshal(map[string]Item{key: item})
+	}
+}
+
+func newRecordDecoder(codec Codec) recordDecoder {
+	if rc, ok := codec.(recordCodec); ok {
+		return rc.newRecordDecoder()
+	}
+	return func(key string, encoded []byte) (Item, error) {
+		return decodeRecord(key, encoded, codec)
+	}
+}
+
+// decodeRecord decodes the item of key from a record of a one-item map.
+func decodeRecord(key string, encoded []byte, codec Codec) (Item, error) {
+	decoded, err := codec.Unmarshal(encoded)
+	if err != nil {
+		return Item{}, err
+	}
+	item, ok := decoded[key]
+	if !ok {
+		return Item{}, ErrMalformedData
+	}
+	return item, nil
+}
+
+// readField reads a field preceded by its uvarint length off data.
+func readField(data []byte) (field, rest []byte, ok bool) {
+	n, l := binary.Uvarint(data)
+	if l <= 0 || uint64(len(data)-l) < n {
+		return nil, nil, false
+	}
+	return data[l : l+int(n)], data[l+int(n):], true
 }
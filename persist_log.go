@@ -0,0 +1,245 @@
+package gcache
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"sync"
+)
+
+// LogPersister is a Persister which also appends every single write to a log,
+// so writes are durable right away and Save only needs to run now and then to
+// compact the log into a snapshot. Load returns the snapshot with the log
+// replayed on top.
+type LogPersister interface {
+	Persister
+
+	// LogSet and LogDel are called with the cache locked, in mutation order.
+	LogSet(key string, item Item) error
+	LogDel(key string) error
+
+	// Checkpoint is called with the cache locked when it takes the snapshot
+	// for the next Save, which must keep the writes logged after it.
+	Checkpoint()
+}
+
+const (
+	kLogOpSet byte = 1
+	kLogOpDel byte = 2
+)
+
+// LogFilePersister keeps a snapshot at FilePath and appends the writes to the
+// log at FilePath + ".log". Each log record is an op byte, a uvarint length
+// and either the key (del) or the Codec-encoded key and item (set). A torn
+// record at the end of the log, e.g. after a crash, is dropped on Load.
+//
+// Each write costs a Codec Marshal of the item and a write to the log file,
+// both with the cache locked, so other calls wait for them. The log isn't
+// synced, so a write reaches the disk once the OS flushes the file.
+type LogFilePersister struct {
+	FilePath string
+	Codec    Codec // GobCodec if nil, MsgpackCodec keeps the records small
+
+	// OnLoadError is called for each snapshot item Load skips, see
+	// FilePersister. Log records which can't be decoded fail the Load.
+	OnLoadError func(key string, err error)
+
+	mtx        sync.Mutex
+	log        *os.File
+	size       int64 // size of the log
+	checkpoint int64 // size of the log at the last Checkpoint
+}
+
+func (p *LogFilePersister) Load() (map[string]Item, error) {
+	snapshot := FilePersister{FilePath: p.FilePath, Codec: p.Codec, OnLoadError: p.OnLoadError}
+	items, err := snapshot.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	data, err := os.ReadFile(p.logPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	size, err := p.replay(data, items)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, item := range items {
+		if item.expired() {
+			delete(items, key)
+		}
+	}
+
+	if err := p.openLog(size); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// Save writes items as the new snapshot, then drops the log records written
+// before the last checkpoint.
+func (p *LogFilePersister) Save(items map[string]Item) error {
+	data, err := encodeSnapshot(items, p.codec())
+	if err != nil {
+		return err
+	}
+
+	tmpPath := p.FilePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0666); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, p.FilePath); err != nil {
+		return err
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.log == nil {
+		return nil
+	}
+
+	tail := make([]byte, p.size-p.checkpoint)
+	if _, err := p.log.ReadAt(tail, p.checkpoint); err != nil {
+		return err
+	}
+
+	tmpPath = p.logPath() + ".tmp"
+	if err := os.WriteFile(tmpPath, tail, 0666); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, p.logPath()); err != nil {
+		return err
+	}
+
+	p.log.Close()
+	p.log = nil
+	return p.openLog(int64(len(tail)))
+}
+
+func (p *LogFilePersister) LogSet(key string, item Item) error {
+	data, err := p.codec().Marshal(map[string]Item{key: item})
+	if err != nil {
+		return err
+	}
+	return p.append(kLogOpSet, data)
+}
+
+func (p *LogFilePersister) LogDel(key string) error {
+	return p.append(kLogOpDel, []byte(key))
+}
+
+func (p *LogFilePersister) Checkpoint() {
+	p.mtx.Lock()
+	p.checkpoint = p.size
+	p.mtx.Unlock()
+}
+
+// Close closes the log, the cache must be closed first.
+func (p *LogFilePersister) Close() error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.log == nil {
+		return nil
+	}
+	err := p.log.Close()
+	p.log = nil
+	return err
+}
+
+func (p *LogFilePersister) append(op byte, payload []byte) error {
+	record := make([]byte, 1+binary.MaxVarintLen64+len(payload))
+	record[0] = op
+	l := binary.PutUvarint(record[1:], uint64(len(payload)))
+	record = append(record[:1+l], payload...)
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.log == nil {
+		var size int64
+		if fi, err := os.Stat(p.logPath()); err == nil {
+			size = fi.Size()
+		}
+		if err := p.openLog(size); err != nil {
+			return err
+		}
+	}
+
+	n, err := p.log.Write(record)
+	p.size += int64(n)
+	return err
+}
+
+// replay applies the log records in data to items, and returns the size of
+// the complete records.
+func (p *LogFilePersister) replay(data []byte, items map[string]Item) (int64, error) {
+	var pos int
+	for pos < len(data) {
+		op := data[pos]
+		n, l := binary.Uvarint(data[pos+1:])
+		if l <= 0 || uint64(len(data)-pos-1-l) < n {
+			break // torn record
+		}
+		payload := data[pos+1+l : pos+1+l+int(n)]
+
+		switch op {
+		case kLogOpSet:
+			decoded, err := p.codec().Unmarshal(payload)
+			if err != nil {
+				return 0, err
+			}
+			for key, item := range decoded {
+				items[key] = item
+			}
+		case kLogOpDel:
+			delete(items, string(payload))
+		default:
+			return 0, ErrMalformedData
+		}
+
+		pos += 1 + l + int(n)
+	}
+
+	return int64(pos), nil
+}
+
+// openLog opens the log for appending, dropping anything beyond size.
+func (p *LogFilePersister) openLog(size int64) error {
+	f, err := os.OpenFile(p.logPath(), os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(size, 0); err != nil {
+		f.Close()
+		return err
+	}
+
+	p.log = f
+	p.size = size
+	p.checkpoint = 0
+	return nil
+}
+
+func (p *LogFilePersister) logPath() string {
+	return p.FilePath + ".log"
+}
+
+func (p *LogFilePersister) codec() Codec {
+	if p.Codec == nil {
+		return GobCodec{}
+	}
+	return p.Codec
+}
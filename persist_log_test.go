@@ -0,0 +1,123 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLogFilePersister(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	persister := &LogFilePersister{FilePath: "persist_log.bin", Codec: MsgpackCodec{}}
+	defer os.Remove(persister.FilePath)
+	defer os.Remove(persister.logPath())
+	defer persister.Close()
+
+	c, err := New(ctx, 0, 0, persister)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+	Set(c, "k2", int64(2), time.Minute)
+	Increase(c, "k2", int64(1))
+	Delete(c, "k1")
+	Set(c, "k3", []string{"a"}, NEVER_EXPIRE)
+
+	// replay the log without any snapshot, as after a crash
+	items, err := (&LogFilePersister{FilePath: persister.FilePath, Codec: MsgpackCodec{}}).Load()
+	if err != nil {
+		t.Error(err)
+		return
+	} else if len(items) != 2 {
+		t.Errorf("invalid number of items, expect: 2, got: %v", len(items))
+		return
+	} else if v := items["k2"].Object; v != int64(3) {
+		t.Errorf("value error. key: k2, expect: 3, got: %v", v)
+		return
+	}
+
+	// compact
+	if err := c.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+	if fi, err := os.Stat(persister.logPath()); err != nil {
+		t.Error(err)
+		return
+	} else if fi.Size() != 0 {
+		t.Errorf("log not compacted, size: %v", fi.Size())
+		return
+	}
+
+	Set(c, "k4", true, NEVER_EXPIRE)
+
+	// append a torn record
+	f, err := os.OpenFile(persister.logPath(), os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	f.Write([]byte{kLogOpSet, 100, 1})
+	f.Close()
+
+	items, err = (&LogFilePersister{FilePath: persister.FilePath, Codec: MsgpackCodec{}}).Load()
+	if err != nil {
+		t.Error(err)
+		return
+	} else if len(items) != 3 {
+		t.Errorf("invalid number of items, expect: 3, got: %v", len(items))
+		return
+	} else if v := items["k4"].Object; v != true {
+		t.Errorf("value error. key: k4, expect: true, got: %v", v)
+		return
+	}
+}
+
+// failingLog is a LogPersister whose appends always fail.
+type failingLog struct {
+	MemoryPersister
+}
+
+var errLogFull = errors.New("log full")
+
+func (l *failingLog) LogSet(key string, item Item) error { return errLogFull }
+func (l *failingLog) LogDel(key string) error            { return errLogFull }
+func (l *failingLog) Checkpoint()                        {}
+
+func TestLogPersisterError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var errs []error
+	c, err := New(ctx, 0, 0, &failingLog{}, WithOnPersistError(func(err error) {
+		errs = append(errs, err)
+	}))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+	if len(errs) != 1 || errs[0] != errLogFull {
+		t.Errorf("invalid persist errors, expect: [%v], got: %v", errLogFull, errs)
+		return
+	}
+	if err := c.LastPersistError(); err != errLogFull {
+		t.Errorf("invalid last persist error, expect: %v, got: %v", errLogFull, err)
+		return
+	}
+
+	Delete(c, "k1")
+	if len(errs) != 2 {
+		t.Errorf("invalid number of persist errors, expect: 2, got: %v", len(errs))
+		return
+	}
+}
@@ -0,0 +1,47 @@
+package gcache
+
+import "sync"
+
+// MemoryPersister keeps the last saved items in memory and returns them on
+// Load, e.g. to test the persist and reload logic without touching disk, or
+// to assert on what would have been persisted. The zero value is ready to
+// use. Slice and map values are copied on Save and Load, so the saved items
+// don't change with the cache.
+type MemoryPersister struct {
+	mtx   sync.Mutex
+	items map[string]Item
+	saves int
+}
+
+func (p *MemoryPersister) Load() (map[string]Item, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return cloneItems(p.items), nil
+}
+
+func (p *MemoryPersister) Save(items map[string]Item) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.items = cloneItems(items)
+	p.saves++
+	return nil
+}
+
+// Saves returns the number of times Save was called.
+func (p *MemoryPersister) Saves() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return p.saves
+}
+
+func cloneItems(items map[string]Item) map[string]Item {
+	cp := make(map[string]Item, len(items))
+	for key, item := range items {
+		item.Object = cloneObject(item.Object)
+		cp[key] = item
+	}
+	return cp
+}
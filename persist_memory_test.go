@@ -0,0 +1,57 @@
+package gcache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryPersister(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	persister := &MemoryPersister{}
+	c, err := New(ctx, 0, time.Hour, persister)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+	Set(c, "k2", map[string]int{"a": 1}, time.Hour)
+	if err := c.Flush(); err != nil {
+		t.Error("flush error:", err)
+		return
+	}
+
+	items, _ := persister.Load()
+	if len(items) != 2 || items["k1"].Object != "v1" || persister.Saves() != 1 {
+		t.Errorf("invalid items saved, got: %v", items)
+		return
+	}
+
+	// the saved items don't change with the cache
+	InsertToMap(c, "k2", "b", 2)
+	items, _ = persister.Load()
+	if m := items["k2"].Object.(map[string]int); len(m) != 1 {
+		t.Errorf("saved item changed, got: %v", m)
+		return
+	}
+
+	if err := c.Close(); err != nil {
+		t.Error("close error:", err)
+		return
+	}
+
+	c2, err := New(ctx, 0, 0, persister)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c2.Close()
+
+	if v, _ := Get[map[string]int](c2, "k2"); len(v) != 2 || v["b"] != 2 {
+		t.Errorf("invalid reloaded value, got: %v", v)
+		return
+	}
+}
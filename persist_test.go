@@ -0,0 +1,414 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFilePersisterLoad(t *testing.T) {
+	persister := &FilePersister{FilePath: "persist_load.bin"}
+	defer os.Remove(persister.FilePath)
+
+	// absent file
+	if items, err := persister.Load(); err != nil {
+		t.Error(err)
+		return
+	} else if len(items) != 0 {
+		t.Errorf("invalid number of items, expect: 0, got: %v", len(items))
+		return
+	}
+
+	// empty file, created by the previous Load
+	if items, err := persister.Load(); err != nil {
+		t.Error(err)
+		return
+	} else if len(items) != 0 {
+		t.Errorf("invalid number of items, expect: 0, got: %v", len(items))
+		return
+	}
+
+	// corrupt file
+	if err := os.WriteFile(persister.FilePath, []byte("corrupt"), 0666); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := persister.Load(); err == nil {
+		t.Error("expect decode error for a corrupt file")
+		return
+	}
+}
+
+// Keys deleted by DeleteKeys right before Close used to be resurrected on the
+// next Load, as the deletion didn't count as a change to persist.
+func TestFilePersisterDeleteKeys(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	persister := &FilePersister{FilePath: "persist_delete.bin"}
+	defer os.Remove(persister.FilePath)
+
+	c, err := New(ctx, time.Hour, time.Hour, persister)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+	Set(c, "k2", "v2", NEVER_EXPIRE)
+	if err := c.Flush(); err != nil {
+		t.Error("flush error:", err)
+		return
+	}
+
+	DeleteKeys(c, []string{"k1", "k2"})
+	if err := c.Close(); err != nil {
+		t.Error("close error:", err)
+		return
+	}
+
+	c2, err := New(ctx, time.Hour, time.Hour, persister)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c2.Close()
+
+	if n := Len(c2); n != 0 {
+		t.Errorf("deleted keys resurrected, got %v items", n)
+		return
+	}
+}
+
+func TestFilePersisterFormat(t *testing.T) {
+	persister := &FilePersister{FilePath: "persist_format.bin"}
+	defer os.Remove(persister.FilePath)
+
+	items := map[string]Item{"k1": {Object: "v1", ExpireMs: kNeverExpireMs}}
+	if err := persister.Save(items); err != nil {
+		t.Error(err)
+		return
+	}
+	data, err := os.ReadFile(persister.FilePath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(data[:len(kFormatMagic)]) != kFormatMagic || data[len(kFormatMagic)] != kFormatVersion {
+		t.Error("format header not written")
+		return
+	}
+	if loaded, err := persister.Load(); err != nil || loaded["k1"].Object != "v1" {
+		t.Errorf("load error, got: %v, %v", loaded, err)
+		return
+	}
+
+	// files without header load as version 0
+	legacy, _ := GobCodec{}.Marshal(items)
+	os.WriteFile(persister.FilePath, legacy, 0666)
+	if loaded, err := persister.Load(); err != nil || loaded["k1"].Object != "v1" {
+		t.Errorf("legacy load error, got: %v, %v", loaded, err)
+		return
+	}
+
+	// v1 is the Codec-encoded map of items after the header
+	os.WriteFile(persister.FilePath, append(append([]byte(kFormatMagic), 1), legacy...), 0666)
+	if loaded, err := persister.Load(); err != nil || loaded["k1"].Object != "v1" {
+		t.Errorf("v1 load error, got: %v, %v", loaded, err)
+		return
+	}
+	os.WriteFile(persister.FilePath, legacy, 0666)
+
+	var migrated uint8 = 255
+	persister.Migrate = func(version uint8, payload []byte) (map[string]Item, error) {
+		migrated = version
+		return GobCodec{}.Unmarshal(payload)
+	}
+	if _, err := persister.Load(); err != nil || migrated != 0 {
+		t.Errorf("migration not called, got version %v, %v", migrated, err)
+		return
+	}
+
+	future := append([]byte(kFormatMagic), kFormatVersion+1)
+	os.WriteFile(persister.FilePath, append(future, legacy...), 0666)
+	if _, err := persister.Load(); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("expect ErrUnsupportedFormat, got: %v", err)
+		return
+	}
+}
+
+func TestFilePersisterOnLoadError(t *testing.T) {
+	persister := &FilePersister{FilePath: "persist_load_error.bin"}
+	defer os.Remove(persister.FilePath)
+
+	items := map[string]Item{
+		"k1": {Object: "v1", ExpireMs: kNeverExpireMs},
+		"k2": {Object: "v2", ExpireMs: kNeverExpireMs},
+	}
+	data, err := encodeSnapshot(items, GobCodec{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// append a record whose item can't be decoded
+	data = append(data, 3, 'b', 'a', 'd', 7)
+	data = append(data, "corrupt"...)
+	data[len(kFormatMagic)+1]++ // count the record appended
+	os.WriteFile(persister.FilePath, data, 0666)
+
+	var failed []string
+	persister.OnLoadError = func(key string, err error) {
+		failed = append(failed, key)
+	}
+	loaded, err := persister.Load()
+	if err != nil {
+		t.Error("load error:", err)
+		return
+	}
+	if len(loaded) != 2 || loaded["k1"].Object != "v1" || loaded["k2"].Object != "v2" {
+		t.Errorf("invalid items loaded: %v", loaded)
+		return
+	}
+	if len(failed) != 1 || failed[0] != "bad" {
+		t.Errorf("invalid OnLoadError calls: %v", failed)
+		return
+	}
+
+	// broken framing fails the load
+	os.WriteFile(persister.FilePath, data[:len(data)-1], 0666)
+	if _, err := persister.Load(); !errors.Is(err, ErrMalformedData) {
+		t.Errorf("expect ErrMalformedData, got: %v", err)
+		return
+	}
+}
+
+func TestFailOpen(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	persister := &FilePersister{FilePath: "persist_fail_open.bin"}
+	defer os.Remove(persister.FilePath)
+
+	if err := os.WriteFile(persister.FilePath, []byte("corrupt"), 0666); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := New(ctx, 0, 0, persister); err == nil {
+		t.Error("expect load error without FailOpen")
+		return
+	}
+
+	var reported error
+	c, err := New(ctx, 0, 0, persister, WithFailOpen(), WithOnPersistError(func(err error) {
+		reported = err
+	}))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	if reported == nil || c.LastPersistError() != reported {
+		t.Errorf("load error not reported, got: %v, %v", reported, c.LastPersistError())
+		return
+	}
+	if n := Len(c); n != 0 {
+		t.Errorf("expect an empty cache, got %v items", n)
+		return
+	}
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+	if err := c.Flush(); err != nil {
+		t.Error("flush error:", err)
+		return
+	}
+	if items, err := persister.Load(); err != nil || len(items) != 1 {
+		t.Errorf("invalid items persisted, got: %v, %v", items, err)
+		return
+	}
+}
+
+func TestFilePersisterLoadEach(t *testing.T) {
+	persister := &FilePersister{FilePath: "persist_load_each.bin"}
+	defer os.Remove(persister.FilePath)
+
+	items := map[string]Item{
+		"k1": {Object: "v1", ExpireMs: kNeverExpireMs},
+		"k2": {Object: 2, ExpireMs: time.Now().Add(time.Hour).UnixMilli()},
+		"k3": {Object: "v3", ExpireMs: time.Now().Add(-time.Hour).UnixMilli()},
+	}
+	data, err := encodeSnapshot(items, GobCodec{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data = append(data, 3, 'b', 'a', 'd', 7)
+	data = append(data, "corrupt"...)
+	data[len(kFormatMagic)+1]++ // count the record appended
+	os.WriteFile(persister.FilePath, data, 0666)
+
+	var failed []string
+	persister.OnLoadError = func(key string, err error) {
+		failed = append(failed, key)
+	}
+	loaded := make(map[string]Item)
+	collect := func(key string, item Item) {
+		loaded[key] = item
+	}
+	if err := persister.LoadEach(func(persistent, volatile int) {}, collect); err != nil {
+		t.Error("load error:", err)
+		return
+	}
+	if len(loaded) != 2 || loaded["k1"].Object != "v1" || loaded["k2"].Object != 2 {
+		t.Errorf("invalid items loaded, expect k1 and k2, got: %v", loaded)
+		return
+	}
+	if len(failed) != 1 || failed[0] != "bad" {
+		t.Errorf("invalid OnLoadError calls: %v", failed)
+		return
+	}
+
+	// truncated
+	os.WriteFile(persister.FilePath, data[:len(data)-1], 0666)
+	if err := persister.LoadEach(func(persistent, volatile int) {}, collect); !errors.Is(err, ErrMalformedData) {
+		t.Errorf("expect ErrMalformedData, got: %v", err)
+		return
+	}
+
+	// files in an older format load whole
+	legacy, _ := GobCodec{}.Marshal(items)
+	os.WriteFile(persister.FilePath, legacy, 0666)
+	loaded = make(map[string]Item)
+	if err := persister.LoadEach(func(persistent, volatile int) {}, collect); err != nil || len(loaded) != 2 {
+		t.Errorf("legacy load error, got: %v, %v", loaded, err)
+		return
+	}
+}
+
+func TestFilePersisterMissingRecords(t *testing.T) {
+	persister := &FilePersister{FilePath: "persist_missing_records.bin"}
+	defer os.Remove(persister.FilePath)
+
+	items := map[string]Item{
+		"k1": {Object: "v1", ExpireMs: kNeverExpireMs},
+		"k2": {Object: "v2", ExpireMs: kNeverExpireMs},
+	}
+	data, err := encodeSnapshot(items, GobCodec{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// cut the file off at the record boundary
+	data[len(kFormatMagic)+1]++
+	os.WriteFile(persister.FilePath, data, 0666)
+
+	if _, err := persister.Load(); !errors.Is(err, ErrMalformedData) {
+		t.Errorf("expect ErrMalformedData, got: %v", err)
+		return
+	}
+	if err := persister.LoadEach(func(persistent, volatile int) {}, func(key string, item Item) {}); !errors.Is(err, ErrMalformedData) {
+		t.Errorf("expect ErrMalformedData, got: %v", err)
+		return
+	}
+
+	// more never expiring items than items
+	data[len(kFormatMagic)+1]--
+	data[len(kFormatMagic)+2] = 3
+	os.WriteFile(persister.FilePath, data, 0666)
+
+	if _, err := persister.Load(); !errors.Is(err, ErrMalformedData) {
+		t.Errorf("expect ErrMalformedData, got: %v", err)
+		return
+	}
+	if err := persister.LoadEach(func(persistent, volatile int) {}, func(key string, item Item) {}); !errors.Is(err, ErrMalformedData) {
+		t.Errorf("expect ErrMalformedData, got: %v", err)
+		return
+	}
+}
+
+type recordPoint struct{ X, Y int }
+
+func TestGobRecordStream(t *testing.T) {
+	RegisterType(recordPoint{})
+
+	items := make(map[string]Item)
+	for i := 0; i < 100; i++ {
+		items[fmt.Sprint("k", i)] = Item{Object: recordPoint{i, i}, ExpireMs: kNeverExpireMs}
+	}
+	data, err := encodeSnapshot(items, GobCodec{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// the type information is written once, not once per item
+	one, _ := GobCodec{}.Marshal(map[string]Item{"k0": items["k0"]})
+	if len(data) > 100*len(one)/2 {
+		t.Errorf("snapshot too large, %v bytes for 100 items of %v bytes each", len(data), len(one))
+		return
+	}
+
+	loaded, err := decodeSnapshot(data, GobCodec{}, nil, nil)
+	if err != nil {
+		t.Error("decode error:", err)
+		return
+	}
+	if !reflect.DeepEqual(loaded, items) {
+		t.Error("items not decoded back")
+		return
+	}
+}
+
+func TestLoadSizesMaps(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	persister := &FilePersister{FilePath: "persist_load_size.bin"}
+	defer os.Remove(persister.FilePath)
+
+	items := make(map[string]Item)
+	for i := 0; i < 1500; i++ {
+		item := Item{Object: i, ExpireMs: kNeverExpireMs}
+		if i%3 != 0 {
+			item.ExpireMs = time.Now().Add(time.Hour).UnixMilli()
+		}
+		items[fmt.Sprint("k", i)] = item
+	}
+	if err := persister.Save(items); err != nil {
+		t.Error(err)
+		return
+	}
+
+	var persistent, volatile int
+	err := persister.LoadEach(func(p, v int) {
+		persistent, volatile = p, v
+	}, func(key string, item Item) {})
+	if err != nil {
+		t.Error("load error:", err)
+		return
+	}
+	if persistent != 500 || volatile != 1000 {
+		t.Errorf("invalid size, expect: 500, 1000, got: %v, %v", persistent, volatile)
+		return
+	}
+
+	c, err := New(ctx, 0, 0, persister)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	// sized up front, the expiry heap never grew
+	if n := cap(c.expiries); n != 1000 {
+		t.Errorf("expiries not sized, expect capacity: 1000, got: %v", n)
+		return
+	}
+	if n := Len(c); n != 1500 {
+		t.Errorf("invalid number of items, expect: 1500, got: %v", n)
+		return
+	}
+}
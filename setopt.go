@@ -0,0 +1,110 @@
+package gcache
+
+import "time"
+
+// SetOption configures a single write of SetWith.
+type SetOption func(*setOptions)
+
+type setOptions struct {
+	ttl        time.Duration
+	cond       setCond
+	copyValue  bool
+	skipWriter bool
+}
+
+type setCond int
+
+const (
+	setAlways setCond = iota
+	setIfNotExists
+	setIfExists
+)
+
+// TTL sets the TTL of the write, the DefaultTTL if not given.
+func TTL(ttl time.Duration) SetOption {
+	return func(o *setOptions) {
+		o.ttl = ttl
+	}
+}
+
+// IfNotExists only writes if the key doesn't exist, like Redis SET NX.
+func IfNotExists() SetOption {
+	return func(o *setOptions) {
+		o.cond = setIfNotExists
+	}
+}
+
+// IfExists only writes if the key exists, like Redis SET XX.
+func IfExists() SetOption {
+	return func(o *setOptions) {
+		o.cond = setIfExists
+	}
+}
+
+// CopyValue stores a copy of a slice or map value, like CopyOnSet does for
+// all writes.
+func CopyValue() SetOption {
+	return func(o *setOptions) {
+		o.copyValue = true
+	}
+}
+
+// SkipWriter doesn't hand the write over to the Writer, e.g. for a value just
+// read from the backing store.
+func SkipWriter() SetOption {
+	return func(o *setOptions) {
+		o.skipWriter = true
+	}
+}
+
+// SetWith sets the value as configured by opts, so new flags don't need yet
+// another SetXYZ function. It returns whether the value was written, false if
+// IfNotExists or IfExists declined, and fails like SetChecked. The Writer is
+// only called for unconditional writes, like SetStrict it isn't for those
+// with IfNotExists or IfExists, which are decided with the cache locked.
+func SetWith[T ValType](c *Cache, key string, val T, opts ...SetOption) (bool, error) {
+	so := setOptions{ttl: c.opts.defaultTTL}
+	for _, opt := range opts {
+		opt(&so)
+	}
+
+	extKey, key := c.extKey(key), c.key(key)
+
+	var obj interface{} = val
+	if so.copyValue {
+		obj = cloneObject(obj)
+	}
+	if err := checkTTL(so.ttl); err != nil {
+		return false, err
+	}
+	if err := c.validate(key, obj); err != nil {
+		return false, err
+	}
+	unlockWrites := func() {}
+	if so.cond == setAlways && !so.skipWriter {
+		unlockWrites = c.lockWrites(key)
+		if err := c.write(extKey, obj, so.ttl); err != nil {
+			unlockWrites()
+			return false, err
+		}
+	}
+
+	c.mtx.Lock()
+	defer c.unlock()
+	defer unlockWrites() // before unlock calls the callbacks
+
+	if so.cond != setAlways {
+		item, exists := c.persistItems[key]
+		if !exists {
+			item, exists = c.volatileItems[key]
+			exists = exists && !c.expired(item)
+		}
+		if exists != (so.cond == setIfExists) {
+			return false, nil
+		}
+	}
+
+	c.set(key, obj, so.ttl)
+
+	return true, nil
+}
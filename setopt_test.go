@@ -0,0 +1,113 @@
+package gcache
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSetWith(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var writes int32
+	writer := func(key string, val interface{}, ttl time.Duration) error {
+		atomic.AddInt32(&writes, 1)
+		return nil
+	}
+
+	c, err := New(ctx, 0, 0, nil, WithWriter(writer), WithDefaultTTL(time.Hour))
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	if ok, err := SetWith(c, "k1", "v1"); !ok || err != nil {
+		t.Errorf("set error, got: %v, %v", ok, err)
+		return
+	}
+	if ttl, _ := GetTTL(c, "k1"); ttl <= time.Minute || ttl > time.Hour {
+		t.Errorf("default ttl not applied, got: %v", ttl)
+		return
+	}
+
+	if ok, _ := SetWith(c, "k1", "v2", IfNotExists()); ok {
+		t.Error("IfNotExists overwrote an existing key")
+		return
+	}
+	if ok, _ := SetWith(c, "k2", "v2", IfExists()); ok || Exists(c, "k2") {
+		t.Error("IfExists created a missing key")
+		return
+	}
+	if ok, _ := SetWith(c, "k1", "v3", IfExists(), TTL(NEVER_EXPIRE)); !ok {
+		t.Error("IfExists declined an existing key")
+		return
+	}
+	if p, _ := IsPersistent(c, "k1"); !p {
+		t.Error("ttl option not applied")
+		return
+	}
+	if ok, _ := SetWith(c, "k2", "v2", IfNotExists()); !ok {
+		t.Error("IfNotExists declined a missing key")
+		return
+	}
+
+	SetWith(c, "k3", "v3", SkipWriter())
+	if n := atomic.LoadInt32(&writes); n != 1 {
+		t.Errorf("invalid number of writes, expect: 1, got: %v", n)
+		return
+	}
+
+	s := []int{1, 2}
+	SetWith(c, "k4", s, CopyValue())
+	s[0] = 10
+	if v, _ := Get[[]int](c, "k4"); v[0] != 1 {
+		t.Errorf("value not copied, got: %v", v)
+		return
+	}
+
+	if _, err := SetWith(c, "k5", 5, TTL(-time.Second)); err != ErrInvalidTTL {
+		t.Errorf("negative ttl, expect: %v, got: %v", ErrInvalidTTL, err)
+		return
+	}
+}
+
+func TestSetXX(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := &fakeClock{now: time.UnixMilli(10000)}
+	c, err := NewWithClock(ctx, 0, 0, nil, clock)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	if SetXX(c, "k1", "v1", NEVER_EXPIRE) || Exists(c, "k1") {
+		t.Error("missing key created")
+		return
+	}
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+	if !SetXX(c, "k1", "v2", time.Second) {
+		t.Error("existing key not written")
+		return
+	}
+	if v, _ := Get[string](c, "k1"); v != "v2" {
+		t.Errorf("invalid value, expect: v2, got: %v", v)
+		return
+	}
+	if p, _ := IsPersistent(c, "k1"); p {
+		t.Error("persistent item not moved to volatile")
+		return
+	}
+
+	clock.Advance(time.Second * 2)
+	if SetXX(c, "k1", "v3", NEVER_EXPIRE) || Exists(c, "k1") {
+		t.Error("expired key written")
+		return
+	}
+}
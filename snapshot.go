@@ -0,0 +1,70 @@
+package gcache
+
+import "time"
+
+// Snapshot is an immutable copy of the unexpired items of a cache at the time
+// it was taken, e.g. to process the whole cache consistently while writers
+// go on. Items don't expire in the snapshot. A snapshot of a Namespace only
+// holds the keys of the namespace, unprefixed.
+type Snapshot struct {
+	items        map[string]Item
+	keyTransform func(key string) string
+	taken        time.Time
+}
+
+// Snapshot copies the unexpired items, deep copying slice and map values so
+// the snapshot never changes. It holds the read lock while copying, so it
+// costs about as much as CopyFrom.
+func (c *Cache) Snapshot() *Snapshot {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	s := &Snapshot{
+		items:        make(map[string]Item, len(c.persistItems)+len(c.volatileItems)),
+		keyTransform: c.opts.keyTransform,
+		taken:        c.clock.Now(),
+	}
+
+	nowMs := c.nowMs()
+	for _, items := range []map[string]Item{c.persistItems, c.volatileItems} {
+		for key, item := range items {
+			own, ok := c.ownKey(key)
+			if !ok || item.expiredAt(nowMs) {
+				continue
+			}
+			item.Object = cloneObject(item.Object)
+			s.items[own] = item
+		}
+	}
+
+	return s
+}
+
+// Get returns the object of key and whether it exists. Slice and map objects
+// are shared with the snapshot and must not be mutated.
+func (s *Snapshot) Get(key string) (interface{}, bool) {
+	if s.keyTransform != nil {
+		key = s.keyTransform(key)
+	}
+	item, exists := s.items[key]
+	return item.Object, exists
+}
+
+// Keys returns the keys of the snapshot.
+func (s *Snapshot) Keys() []string {
+	keys := make([]string, 0, len(s.items))
+	for key := range s.items {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Len returns the number of items of the snapshot.
+func (s *Snapshot) Len() int {
+	return len(s.items)
+}
+
+// Taken returns the time the snapshot was taken.
+func (s *Snapshot) Taken() time.Time {
+	return s.taken
+}
@@ -0,0 +1,59 @@
+package gcache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+	Set(c, "k2", map[string]int{"a": 1}, time.Minute)
+	Set(c, "k3", 3, time.Millisecond)
+	Set(c, "ns:k4", 4, NEVER_EXPIRE)
+	time.Sleep(time.Millisecond * 5)
+
+	s := c.Snapshot()
+	if s.Len() != 3 || len(s.Keys()) != 3 {
+		t.Errorf("invalid snapshot, got keys: %v", s.Keys())
+		return
+	}
+	if _, exists := s.Get("k3"); exists {
+		t.Error("expired item in the snapshot")
+		return
+	}
+
+	// writers go on without changing the snapshot
+	Set(c, "k1", "v2", NEVER_EXPIRE)
+	InsertToMap(c, "k2", "b", 2)
+	Delete(c, "ns:k4")
+	if v, _ := s.Get("k1"); v != "v1" {
+		t.Errorf("snapshot changed, expect: v1, got: %v", v)
+		return
+	}
+	if v, _ := s.Get("k2"); len(v.(map[string]int)) != 1 {
+		t.Errorf("snapshot value mutated, got: %v", v)
+		return
+	}
+	if _, exists := s.Get("ns:k4"); !exists {
+		t.Error("deleted key missing from the snapshot")
+		return
+	}
+
+	Set(c, "ns:k5", 5, NEVER_EXPIRE)
+	ns := c.Namespace("ns").Snapshot()
+	if keys := ns.Keys(); len(keys) != 1 || keys[0] != "k5" {
+		t.Errorf("invalid namespace snapshot, got keys: %v", keys)
+		return
+	}
+}
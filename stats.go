@@ -0,0 +1,144 @@
+package gcache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a point-in-time summary of the cache, see Stats and OnTick.
+type Stats struct {
+	Items      int    // all items, including expired ones not yet cleaned up
+	ValidItems int    // unexpired items
+	Bytes      int64  // approximate size of the keys and values
+	Hits       uint64 // lookups of Get and GetAny which found the key
+	Misses     uint64 // lookups of Get and GetAny which didn't
+
+	// Loader metrics, zero without a Loader. Misses which waited for the call
+	// of a concurrent miss don't count as calls.
+	LoaderCalls  uint64        // calls of the Loader
+	LoaderErrors uint64        // loads which failed, by the Loader or the Validator
+	LoaderTime   time.Duration // total time spent in the Loader
+}
+
+// AvgLoaderTime returns the average time of a Loader call, 0 if none.
+func (s Stats) AvgLoaderTime() time.Duration {
+	if s.LoaderCalls == 0 {
+		return 0
+	}
+	return s.LoaderTime / time.Duration(s.LoaderCalls)
+}
+
+// Stats returns the current stats. Computing Bytes walks all the items, so
+// it is expensive on a large cache.
+func (c *Cache) Stats() Stats {
+	var stats Stats
+
+	c.mtx.RLock()
+	nowMs := c.nowMs()
+	for key, item := range c.persistItems {
+		stats.ValidItems++
+		stats.Bytes += int64(len(key)) + approxSize(item.Object)
+	}
+	for key, item := range c.volatileItems {
+		if !item.expiredAt(nowMs) {
+			stats.ValidItems++
+		}
+		stats.Bytes += int64(len(key)) + approxSize(item.Object)
+	}
+	stats.Items = len(c.persistItems) + len(c.volatileItems)
+	c.mtx.RUnlock()
+
+	stats.Hits = atomic.LoadUint64(&c.hits)
+	stats.Misses = atomic.LoadUint64(&c.misses)
+	stats.LoaderCalls = atomic.LoadUint64(&c.loaderCalls)
+	stats.LoaderErrors = atomic.LoadUint64(&c.loaderErrors)
+	stats.LoaderTime = time.Duration(atomic.LoadInt64(&c.loaderNanos))
+
+	return stats
+}
+
+// Diagnostics is a cheap point-in-time snapshot of the cache health, see
+// Diagnostics.
+type Diagnostics struct {
+	PersistentItems     int           // items which never expire
+	VolatileItems       int           // items with a TTL, including expired ones not yet cleaned up
+	PendingPersistBytes int64         // see PendingPersistBytes
+	Hits                uint64        // see Stats
+	Misses              uint64        // see Stats
+	DroppedEvents       uint64        // see DroppedEvents
+	HasPersister        bool          // whether the cache persists its items
+	LastPersist         time.Time     // see LastPersistTime
+	LastPersistError    error         // see LastPersistError
+	CleanupInterval     time.Duration // current cleanup interval, non-positive if disabled
+	PersistInterval     time.Duration // current persist interval, non-positive if disabled
+}
+
+// Diagnostics returns the health of the cache, e.g. for a debug endpoint. It
+// doesn't walk the items, unlike Stats, so it is safe to call frequently; the
+// number of unexpired volatile items and the size of the items are only
+// known by Stats. The Namespace views report the whole cache.
+func (c *Cache) Diagnostics() Diagnostics {
+	var d Diagnostics
+
+	c.mtx.RLock()
+	d.PersistentItems = len(c.persistItems)
+	d.VolatileItems = len(c.volatileItems)
+	d.LastPersistError = c.persistErr
+	c.mtx.RUnlock()
+
+	d.PendingPersistBytes = c.PendingPersistBytes()
+	d.Hits = atomic.LoadUint64(&c.hits)
+	d.Misses = atomic.LoadUint64(&c.misses)
+	d.DroppedEvents = atomic.LoadUint64(&c.droppedEvents)
+	d.HasPersister = c.persister != nil
+	d.LastPersist = c.LastPersistTime()
+	d.CleanupInterval, d.PersistInterval = c.w.intervals()
+
+	return d
+}
+
+// OnTick makes the watcher call fn with the current stats after each cleanup,
+// e.g. to push metrics. fn is called without the lock held, and a panic in fn
+// is recovered. A nil fn removes the callback.
+func (c *Cache) OnTick(fn func(stats Stats)) {
+	c.mtx.Lock()
+	c.onTick = fn
+	c.unlock()
+}
+
+// tick runs the cleanup, sheds items under memory pressure, compacts the
+// cache if due, then runs the OnTick callback.
+func (c *Cache) tick() {
+	c.cleanup()
+	if c.opts.memoryPressure != nil && c.opts.memoryPressure() {
+		c.Shed(c.opts.shedFraction)
+	}
+	if d := c.opts.compactInterval.Milliseconds(); d > 0 {
+		c.mtx.Lock()
+		if c.nowMs()-c.lastCompactMs >= d {
+			c.compact()
+		}
+		c.unlock()
+	}
+
+	c.mtx.RLock()
+	fn := c.onTick
+	c.mtx.RUnlock()
+	if fn == nil {
+		return
+	}
+
+	defer func() {
+		recover()
+	}()
+	fn(c.Stats())
+}
+
+// countLookup counts a hit or a miss for Stats.
+func (c *Cache) countLookup(hit bool) {
+	if hit {
+		atomic.AddUint64(&c.hits, 1)
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+	}
+}
@@ -0,0 +1,123 @@
+package gcache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := &fakeClock{now: time.Now()}
+	c, err := NewWithClock(ctx, 0, 0, nil, clock)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", "abcd", NEVER_EXPIRE)
+	Set(c, "k2", []int32{1, 2}, time.Minute)
+	Set(c, "k3", map[string]string{"a": "bc"}, time.Second)
+	clock.Advance(time.Second * 2)
+
+	Get[string](c, "k1")
+	Get[string](c, "k3")
+	Get[string](c, "k4")
+
+	stats := c.Stats()
+	expect := Stats{Items: 3, ValidItems: 2, Bytes: 6 + 4 + 8 + 3, Hits: 1, Misses: 2}
+	if stats != expect {
+		t.Errorf("stats error, expect: %+v, got: %+v", expect, stats)
+		return
+	}
+}
+
+func TestOnTick(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, time.Millisecond*10, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", 1, NEVER_EXPIRE)
+
+	ticks := make(chan Stats, 1)
+	c.OnTick(func(stats Stats) {
+		select {
+		case ticks <- stats:
+		default:
+		}
+		panic("recovered")
+	})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case stats := <-ticks:
+			if stats.Items != 1 {
+				t.Errorf("invalid number of items, expect: 1, got: %v", stats.Items)
+				return
+			}
+		case <-time.After(time.Second):
+			t.Error("tick timeout")
+			return
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, time.Hour, time.Hour, &MemoryPersister{})
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "k1", "v1", NEVER_EXPIRE)
+	Set(c, "k2", "v2", time.Minute)
+	Get[string](c, "k1")
+	Get[string](c, "k3")
+
+	d := c.Diagnostics()
+	if d.PersistentItems != 1 || d.VolatileItems != 1 || d.Hits != 1 || d.Misses != 1 {
+		t.Errorf("invalid counts, got: %+v", d)
+		return
+	}
+	if !d.HasPersister || !d.LastPersist.IsZero() || d.LastPersistError != nil {
+		t.Errorf("invalid persist state before persisting, got: %+v", d)
+		return
+	}
+	if d.CleanupInterval != time.Hour || d.PersistInterval != time.Hour {
+		t.Errorf("invalid intervals, got: %v, %v", d.CleanupInterval, d.PersistInterval)
+		return
+	}
+
+	before := time.Now()
+	if err := c.Flush(); err != nil {
+		t.Error("flush error:", err)
+		return
+	}
+	if d := c.Diagnostics(); d.LastPersist.Before(before) {
+		t.Errorf("last persist not recorded, got: %v", d.LastPersist)
+		return
+	}
+
+	c.SetCleanupInterval(time.Minute)
+	deadline := time.Now().Add(time.Second)
+	for c.Diagnostics().CleanupInterval != time.Minute {
+		if time.Now().After(deadline) {
+			t.Error("cleanup interval not updated")
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,59 @@
+package gcache
+
+import "time"
+
+// Per-item expiry timers, enabled by WithExpiryTimers. c.timers is nil when
+// disabled, so all helpers are no-ops then. Must be called with c.mtx held.
+// Timers always run in real time, also with a fake Clock.
+
+// scheduleTimer (re)arms the timer of a volatile item so it gets removed right
+// at its expiration.
+func (c *Cache) scheduleTimer(key string, expireMs int64) {
+	if c.timers == nil {
+		return
+	}
+
+	c.stopTimer(key)
+
+	var t *time.Timer
+	t = time.AfterFunc(time.Duration(expireMs+1-c.nowMs())*time.Millisecond, func() {
+		c.mtx.Lock()
+		defer c.unlock()
+
+		// t is assigned under c.mtx before this can run
+		if c.timers[key] != t {
+			return
+		}
+		delete(c.timers, key)
+
+		if item, exists := c.volatileItems[key]; exists && c.expired(item) {
+			delete(c.volatileItems, key)
+			c.changes++
+			c.record(key, EventExpired, item)
+			c.notifyEmpty()
+		}
+	})
+	c.timers[key] = t
+}
+
+func (c *Cache) stopTimer(key string) {
+	if c.timers == nil {
+		return
+	}
+
+	if t, exists := c.timers[key]; exists {
+		t.Stop()
+		delete(c.timers, key)
+	}
+}
+
+func (c *Cache) stopAllTimers() {
+	if c.timers == nil {
+		return
+	}
+
+	for _, t := range c.timers {
+		t.Stop()
+	}
+	c.timers = make(map[string]*time.Timer)
+}
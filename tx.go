@@ -0,0 +1,51 @@
+package gcache
+
+import "time"
+
+// Tx gives access to the cache while WithLock holds its lock. Its methods
+// are unchecked: they skip MaxKeyLen, the Validator, the TypeCheck, the
+// Loader and the Writer, but still fire events and count as changes to
+// persist. A Tx is only valid during the WithLock call it was passed to.
+type Tx struct {
+	c *Cache
+}
+
+// WithLock calls fn with the cache locked for writing, so fn can compose
+// several operations through tx atomically, e.g. move a value between keys
+// only if the target is absent.
+//
+// fn must be short and must not call back into the cache other than through
+// tx: any other call of c, or of a Namespace of c, deadlocks. This includes
+// goroutines spawned by fn which fn waits for, and those outliving fn must
+// not use tx either. Readers and writers are blocked until fn returns.
+func (c *Cache) WithLock(fn func(tx *Tx)) {
+	c.mtx.Lock()
+	defer c.unlock()
+
+	fn(&Tx{c: c})
+}
+
+// Get returns the object of key and whether it exists and isn't expired.
+func (tx *Tx) Get(key string) (interface{}, bool) {
+	key = tx.c.key(key)
+
+	if item, exists := tx.c.persistItems[key]; exists {
+		return item.Object, true
+	}
+	item, exists := tx.c.volatileItems[key]
+	if !exists || tx.c.expired(item) {
+		return nil, false
+	}
+	return item.Object, true
+}
+
+// Set stores val under key with ttl.
+func (tx *Tx) Set(key string, val interface{}, ttl time.Duration) {
+	tx.c.set(tx.c.key(key), val, ttl)
+}
+
+// Del deletes key and tells whether it existed and wasn't expired.
+func (tx *Tx) Del(key string) bool {
+	_, err := tx.c.take(tx.c.key(key), nil)
+	return err == nil
+}
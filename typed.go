@@ -0,0 +1,75 @@
+package gcache
+
+import "time"
+
+// TypedCache fixes the value type of a cache to T, so its methods need no type
+// parameter and callers can't store values of another type by mistake. It
+// forwards to the generic functions, and embeds the Cache for Close, Flush and
+// the like; values stored through the Cache or another TypedCache of the same
+// Cache may still be of another type, and fail with ErrInvalidType.
+type TypedCache[T ValType] struct {
+	*Cache
+}
+
+// NewTypedCache wraps c, which may be a Namespace, to hold values of type T.
+func NewTypedCache[T ValType](c *Cache) TypedCache[T] {
+	return TypedCache[T]{Cache: c}
+}
+
+func (tc TypedCache[T]) Get(key string) (T, error) {
+	return Get[T](tc.Cache, key)
+}
+
+func (tc TypedCache[T]) TryGet(key string) (T, bool) {
+	return TryGet[T](tc.Cache, key)
+}
+
+func (tc TypedCache[T]) GetOrDefault(key string, def T) T {
+	return GetOrDefault(tc.Cache, key, def)
+}
+
+func (tc TypedCache[T]) GetAndDelete(key string) (T, error) {
+	return GetAndDelete[T](tc.Cache, key)
+}
+
+func (tc TypedCache[T]) Set(key string, val T, ttl time.Duration) {
+	Set(tc.Cache, key, val, ttl)
+}
+
+func (tc TypedCache[T]) SetChecked(key string, val T, ttl time.Duration) error {
+	return SetChecked(tc.Cache, key, val, ttl)
+}
+
+func (tc TypedCache[T]) SetDefault(key string, val T) {
+	SetDefault(tc.Cache, key, val)
+}
+
+func (tc TypedCache[T]) Update(key string, fn func(old T, existed bool) (T, error), ttl time.Duration) (T, error) {
+	return Update(tc.Cache, key, fn, ttl)
+}
+
+func (tc TypedCache[T]) Exists(key string) bool {
+	return Exists(tc.Cache, key)
+}
+
+func (tc TypedCache[T]) Delete(key string) {
+	Delete(tc.Cache, key)
+}
+
+// NumCache is a TypedCache of numbers, which adds the counters.
+type NumCache[T NumType] struct {
+	TypedCache[T]
+}
+
+// NewNumCache wraps c, which may be a Namespace, to hold numbers of type T.
+func NewNumCache[T NumType](c *Cache) NumCache[T] {
+	return NumCache[T]{TypedCache: NewTypedCache[T](c)}
+}
+
+func (nc NumCache[T]) Increase(key string, val T) (T, error) {
+	return Increase(nc.Cache, key, val)
+}
+
+func (nc NumCache[T]) Decrease(key string, val T) (T, error) {
+	return Decrease(nc.Cache, key, val)
+}
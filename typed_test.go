@@ -0,0 +1,55 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTypedCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	config := NewTypedCache[string](c.Namespace("config"))
+	config.Set("host", "localhost", NEVER_EXPIRE)
+	if v, err := config.Get("host"); err != nil || v != "localhost" {
+		t.Errorf("invalid value, got: %v, %v", v, err)
+		return
+	}
+	if v := config.GetOrDefault("port", "80"); v != "80" {
+		t.Errorf("invalid default, got: %v", v)
+		return
+	}
+	if v, _ := Get[string](c, "config:host"); v != "localhost" {
+		t.Errorf("value not stored in the cache, got: %v", v)
+		return
+	}
+
+	Set(c, "config:port", 80, NEVER_EXPIRE)
+	if _, err := config.Get("port"); !errors.Is(err, ErrInvalidType) {
+		t.Errorf("mismatched type, expect: %v, got: %v", ErrInvalidType, err)
+		return
+	}
+
+	counters := NewNumCache[int64](c)
+	counters.Set("hits", 1, NEVER_EXPIRE)
+	if v, err := counters.Increase("hits", 2); err != nil || v != 3 {
+		t.Errorf("invalid increase, expect: 3, got: %v, %v", v, err)
+		return
+	}
+	if v, err := counters.Decrease("hits", 1); err != nil || v != 2 {
+		t.Errorf("invalid decrease, expect: 2, got: %v, %v", v, err)
+		return
+	}
+	if v, err := counters.GetAndDelete("hits"); err != nil || v != 2 || counters.Exists("hits") {
+		t.Errorf("invalid get and delete, got: %v, %v", v, err)
+		return
+	}
+}
@@ -0,0 +1,81 @@
+package gcache
+
+import "reflect"
+
+// View wraps a value with read-only accessors, so callers can read slice and
+// map values without the risk of mutating the cached value and without
+// copying them. A view reads the live value: like Get, it doesn't guard
+// against in-place mutations such as InsertToMap running concurrently.
+type View[T ValType] struct {
+	val T
+}
+
+// Get a read-only view of the value.
+func GetView[T ValType](c *Cache, key string) (View[T], error) {
+	v, err := Get[T](c, key)
+	if err != nil {
+		return View[T]{}, err
+	}
+	return View[T]{val: v}, nil
+}
+
+// Value returns the value, slice and map values are deep copied like GetCopy.
+func (v View[T]) Value() T {
+	return cloneObject(v.val).(T)
+}
+
+// Len returns the number of elements of a slice or map value, 0 for scalars.
+func (v View[T]) Len() int {
+	rv := reflect.ValueOf(v.val)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map:
+		return rv.Len()
+	}
+	return 0
+}
+
+// Index returns element i of a slice value.
+func (v View[T]) Index(i int) (interface{}, error) {
+	rv := reflect.ValueOf(v.val)
+	if rv.Kind() != reflect.Slice {
+		return nil, invalidTypeError(v.val)
+	}
+	if i < 0 || i >= rv.Len() {
+		return nil, ErrIndexOutOfRange
+	}
+	return rv.Index(i).Interface(), nil
+}
+
+// Lookup returns the element name of a map value.
+func (v View[T]) Lookup(name string) (interface{}, bool) {
+	rv := reflect.ValueOf(v.val)
+	if rv.Kind() != reflect.Map {
+		return nil, false
+	}
+	e := rv.MapIndex(reflect.ValueOf(name))
+	if !e.IsValid() {
+		return nil, false
+	}
+	return e.Interface(), true
+}
+
+// Range calls fn for each element of a slice or map value until fn returns
+// false, with the index or the name as key. Scalars have no elements.
+func (v View[T]) Range(fn func(key, val interface{}) bool) {
+	rv := reflect.ValueOf(v.val)
+	switch rv.Kind() {
+	case reflect.Slice:
+		for i := 0; i < rv.Len(); i++ {
+			if !fn(i, rv.Index(i).Interface()) {
+				return
+			}
+		}
+	case reflect.Map:
+		iter := rv.MapRange()
+		for iter.Next() {
+			if !fn(iter.Key().Interface(), iter.Value().Interface()) {
+				return
+			}
+		}
+	}
+}
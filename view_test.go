@@ -0,0 +1,76 @@
+package gcache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestView(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := New(ctx, 0, 0, nil)
+	if err != nil {
+		t.Error("create cache error:", err)
+		return
+	}
+	defer c.Close()
+
+	Set(c, "s", "v", time.Minute)
+	Set(c, "l", []int{1, 2, 3}, time.Minute)
+	Set(c, "m", map[string]bool{"a": true}, time.Minute)
+
+	if _, err := GetView[string](c, "none"); err != ErrNotExists {
+		t.Errorf("expect ErrNotExists, got: %v", err)
+		return
+	}
+
+	sv, err := GetView[string](c, "s")
+	if err != nil || sv.Value() != "v" || sv.Len() != 0 {
+		t.Errorf("scalar view error, got: %v, %v", sv.Value(), err)
+		return
+	}
+
+	lv, err := GetView[[]int](c, "l")
+	if err != nil || lv.Len() != 3 {
+		t.Errorf("slice view error, got: %v, %v", lv.Len(), err)
+		return
+	}
+	if e, err := lv.Index(1); err != nil || e != 2 {
+		t.Errorf("index error, expect: 2, got: %v, %v", e, err)
+		return
+	}
+	if _, err := lv.Index(3); err != ErrIndexOutOfRange {
+		t.Errorf("expect ErrIndexOutOfRange, got: %v", err)
+		return
+	}
+	sum := 0
+	lv.Range(func(key, val interface{}) bool {
+		sum += val.(int)
+		return true
+	})
+	if sum != 6 {
+		t.Errorf("range error, expect sum: 6, got: %v", sum)
+		return
+	}
+	lv.Value()[0] = 100
+	if e, _ := lv.Index(0); e != 1 {
+		t.Error("value not copied")
+		return
+	}
+
+	mv, err := GetView[map[string]bool](c, "m")
+	if err != nil || mv.Len() != 1 {
+		t.Errorf("map view error, got: %v, %v", mv.Len(), err)
+		return
+	}
+	if e, ok := mv.Lookup("a"); !ok || e != true {
+		t.Errorf("lookup error, got: %v, %v", e, ok)
+		return
+	}
+	if _, ok := mv.Lookup("b"); ok {
+		t.Error("lookup of a missing name succeeded")
+		return
+	}
+}
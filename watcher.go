@@ -2,48 +2,118 @@ package gcache
 
 import (
 	"context"
+	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type watcher struct {
-	cleanupInterval time.Duration
-	persistInterval time.Duration
+	cleanupInterval time.Duration      // atomic, see intervals
+	persistInterval time.Duration      // atomic, see intervals
+	cleanupCh       chan time.Duration // new cleanup interval
+	persistCh       chan time.Duration // new persist interval
+	err             error              // error of the final persist
+	jitter          float64            // randomize each interval by ±jitter
+	rnd             *rand.Rand
 }
 
 func (w *watcher) Run(ctx context.Context, wg *sync.WaitGroup,
-	persister Persister, cleanup func(), persist func()) {
+	persisting bool, cleanup func(), persist func(force bool) error) {
 	defer wg.Done()
-	defer persist()
+	defer func() { w.err = persist(true) }()
 
-	cleanupTicker := time.NewTicker(w.cleanupInterval)
-	defer cleanupTicker.Stop()
+	var cleanupTicker, persistTicker *time.Ticker
+	defer func() {
+		resetTicker(cleanupTicker, 0)
+		resetTicker(persistTicker, 0)
+	}()
 
-	if persister == nil {
-		for {
-			select {
-			case <-ctx.Done():
-				return
+	cleanupTicker = resetTicker(nil, w.jittered(w.cleanupInterval))
+	if persisting {
+		persistTicker = resetTicker(nil, w.jittered(w.persistInterval))
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
 
-			case <-cleanupTicker.C:
-				cleanup()
+		case <-tickerC(cleanupTicker):
+			cleanup()
+			if w.jitter > 0 {
+				cleanupTicker = resetTicker(cleanupTicker, w.jittered(w.cleanupInterval))
 			}
-		}
-	} else {
-		persistTicker := time.NewTicker(w.persistInterval)
-		defer persistTicker.Stop()
 
-		for {
-			select {
-			case <-ctx.Done():
-				return
+		case <-tickerC(persistTicker):
+			persist(false)
+			if w.jitter > 0 {
+				persistTicker = resetTicker(persistTicker, w.jittered(w.persistInterval))
+			}
 
-			case <-cleanupTicker.C:
-				cleanup()
+		case d := <-w.cleanupCh:
+			atomic.StoreInt64((*int64)(&w.cleanupInterval), int64(d))
+			cleanupTicker = resetTicker(cleanupTicker, w.jittered(d))
 
-			case <-persistTicker.C:
-				persist()
+		case d := <-w.persistCh:
+			atomic.StoreInt64((*int64)(&w.persistInterval), int64(d))
+			if persisting {
+				persistTicker = resetTicker(persistTicker, w.jittered(d))
 			}
 		}
 	}
 }
+
+// intervals returns the current intervals, safe to call from any goroutine.
+func (w *watcher) intervals() (cleanup, persist time.Duration) {
+	return time.Duration(atomic.LoadInt64((*int64)(&w.cleanupInterval))),
+		time.Duration(atomic.LoadInt64((*int64)(&w.persistInterval)))
+}
+
+// setInterval hands d over to the running watcher through ch. A non-positive
+// d disables the task.
+func (w *watcher) setInterval(ctx context.Context, ch chan time.Duration, d time.Duration) {
+	select {
+	case ch <- d:
+	case <-ctx.Done():
+	}
+}
+
+// jittered randomizes d by ±w.jitter, so that many instances started together
+// spread their work.
+func (w *watcher) jittered(d time.Duration) time.Duration {
+	if w.jitter <= 0 || d <= 0 {
+		return d
+	}
+
+	j := time.Duration((w.rnd.Float64()*2 - 1) * w.jitter * float64(d))
+	if d+j <= 0 {
+		return d
+	}
+	return d + j
+}
+
+// resetTicker stops t if d is non-positive and returns nil, otherwise it
+// returns t, created if needed, ticking every d.
+func resetTicker(t *time.Ticker, d time.Duration) *time.Ticker {
+	if d <= 0 {
+		if t != nil {
+			t.Stop()
+		}
+		return nil
+	}
+
+	if t == nil {
+		return time.NewTicker(d)
+	}
+	t.Reset(d)
+	return t
+}
+
+// tickerC returns the channel of t, nil channel which never fires if t is nil.
+func tickerC(t *time.Ticker) <-chan time.Time {
+	if t == nil {
+		return nil
+	}
+	return t.C
+}
@@ -0,0 +1,32 @@
+package gcache
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestJitter(t *testing.T) {
+	w := watcher{jitter: 0.1, rnd: rand.New(rand.NewSource(1))}
+
+	d := time.Second
+	distinct := make(map[time.Duration]struct{})
+	for i := 0; i < 100; i++ {
+		j := w.jittered(d)
+		if j < d*9/10 || j > d*11/10 {
+			t.Errorf("jittered interval out of range: %v", j)
+			return
+		}
+		distinct[j] = struct{}{}
+	}
+	if len(distinct) < 2 {
+		t.Error("interval not jittered")
+		return
+	}
+
+	w.jitter = 0
+	if j := w.jittered(d); j != d {
+		t.Errorf("jitter not disabled, expect: %v, got: %v", d, j)
+		return
+	}
+}
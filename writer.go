@@ -0,0 +1,130 @@
+package gcache
+
+import (
+	"sort"
+	"time"
+)
+
+// Writer propagates a write to a backing store, see WithWriter.
+type Writer func(key string, val interface{}, ttl time.Duration) error
+
+type pendingWrite struct {
+	val  interface{}
+	ttl  time.Duration
+	size int64 // approximate size of the key and value
+}
+
+// kWriteLocks is the number of stripes of the per-key write locks.
+const kWriteLocks = 64
+
+// lockWrites locks the keys for writing through the Writer, so the writes of
+// a key reach the Writer in the order they are stored, without holding c.mtx
+// while the Writer runs. Keys share a lock by their hash. It returns the
+// function to unlock them, which must be called before unlock calls the
+// callbacks, as they may write to the cache.
+func (c *Cache) lockWrites(keys ...string) func() {
+	if c.opts.writer == nil {
+		return func() {}
+	}
+
+	stripes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		stripes = append(stripes, int(hashKey(key)%kWriteLocks))
+	}
+	// lock in order, so concurrent batches can't deadlock
+	sort.Ints(stripes)
+	n := 0
+	for i, s := range stripes {
+		if i == 0 || s != stripes[i-1] {
+			stripes[n] = s
+			n++
+			c.writeLocks[s].Lock()
+		}
+	}
+	stripes = stripes[:n]
+
+	return func() {
+		for _, s := range stripes {
+			c.writeLocks[s].Unlock()
+		}
+	}
+}
+
+// write hands a write over to the writer, right away or, with WriteBehind,
+// through the buffer flushed on the persist tick.
+func (c *Cache) write(key string, val interface{}, ttl time.Duration) error {
+	if c.opts.writer == nil {
+		return nil
+	}
+	if !c.opts.writeBehind {
+		return c.opts.writer(key, val, ttl)
+	}
+
+	w := pendingWrite{val: val, ttl: ttl, size: int64(len(key)) + approxSize(val)}
+
+	c.writesMtx.Lock()
+	defer c.writesMtx.Unlock()
+
+	// a write larger than the bound alone is accepted once nothing else is
+	// pending, or its key could never be written
+	delta := w.size - c.writes[key].size
+	others := c.writesBytes - c.writes[key].size
+	if c.opts.maxPendingBytes > 0 && delta > 0 && others > 0 && c.writesBytes+delta > c.opts.maxPendingBytes {
+		return ErrPersistBacklog
+	}
+
+	if c.writes == nil {
+		c.writes = make(map[string]pendingWrite)
+	}
+	c.writes[key] = w
+	c.writesBytes += delta
+
+	return nil
+}
+
+// PendingPersistBytes returns the approximate size of the writes buffered for
+// the Writer with WriteBehind, including those being written.
+func (c *Cache) PendingPersistBytes() int64 {
+	c.writesMtx.Lock()
+	defer c.writesMtx.Unlock()
+
+	return c.writesBytes
+}
+
+// flushWrites hands the buffered writes over to the writer. Failed writes are
+// buffered again, unless the key was written meanwhile, to be retried on the
+// next flush.
+func (c *Cache) flushWrites() error {
+	c.flushMtx.Lock()
+	defer c.flushMtx.Unlock()
+
+	c.writesMtx.Lock()
+	writes := c.writes
+	c.writes = nil
+	c.writesMtx.Unlock()
+
+	var firstErr error
+	for key, w := range writes {
+		err := c.opts.writer(key, w.val, w.ttl)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+
+		c.writesMtx.Lock()
+		if _, ok := c.writes[key]; err == nil || ok {
+			c.writesBytes -= w.size
+		} else {
+			if c.writes == nil {
+				c.writes = make(map[string]pendingWrite)
+			}
+			c.writes[key] = w
+		}
+		c.writesMtx.Unlock()
+	}
+
+	if firstErr != nil && c.opts.onPersistError != nil {
+		c.opts.onPersistError(firstErr)
+	}
+
+	return firstErr
+}